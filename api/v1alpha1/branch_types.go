@@ -28,11 +28,65 @@ type BranchSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	ProjectId        string  `json:"projectId"`
-	ParentId         *string `json:"parentId,omitempty"`
+	// ProjectId is the id of the Neon project of the branch. Either it or
+	// ProjectRef must be set, unless the project is taken from ParentRef.
+	// +optional
+	ProjectId string `json:"projectId,omitempty"`
+	// ProjectRef is the name of a Project resource in the namespace of the
+	// branch, used instead of ProjectId.
+	// +optional
+	ProjectRef string  `json:"projectRef,omitempty"`
+	ParentId   *string `json:"parentId,omitempty"`
+	// ParentRef is the name of a Branch resource in the namespace of the
+	// branch, used instead of ParentId. The branch is created once the
+	// parent exists in Neon, in the project of the parent if neither
+	// ProjectId nor ProjectRef is set.
+	// +optional
+	ParentRef        string  `json:"parentRef,omitempty"`
 	ParentStartPoint *Parent `json:"parentStartPoint,omitempty"`
+
+	// AdoptionPolicy decides what happens when the branch has not been
+	// created by this resource yet but a branch with the same name already
+	// exists in the project.
+	// +kubebuilder:default=Adopt
+	// +optional
+	AdoptionPolicy AdoptionPolicy `json:"adoptionPolicy,omitempty"`
+
+	// CredentialsRef selects the Secret holding the Neon API key used for
+	// this branch. The operator's default key is used if it is not set.
+	// +optional
+	CredentialsRef *CredentialsRef `json:"credentialsRef,omitempty"`
+}
+
+// CredentialsRef references a Secret in the namespace of the resource that
+// holds a Neon API key.
+type CredentialsRef struct {
+	// Name of the Secret.
+	Name string `json:"name"`
+	// Key of the API key in the Secret.
+	// +kubebuilder:default=neon-api-key
+	// +optional
+	Key string `json:"key,omitempty"`
 }
 
+// AdoptionPolicy decides how a resource treats an existing Neon object that
+// matches it, for example when its status was lost after a restore.
+// +kubebuilder:validation:Enum=Adopt;Fail;Create
+type AdoptionPolicy string
+
+const (
+	// AdoptionPolicyAdopt takes over the existing object. It is the default.
+	AdoptionPolicyAdopt AdoptionPolicy = "Adopt"
+	// AdoptionPolicyFail reports an error and leaves the object alone.
+	AdoptionPolicyFail AdoptionPolicy = "Fail"
+	// AdoptionPolicyCreate ignores the existing object and creates another.
+	AdoptionPolicyCreate AdoptionPolicy = "Create"
+)
+
+// ConditionDegraded is the condition type set on resources while the
+// operator cannot reach the Neon API.
+const ConditionDegraded = "Degraded"
+
 // +kubebuilder:validation:MaxProperties=1
 type Parent struct {
 	Lsn       *string `json:"lsn,omitempty"`
@@ -51,6 +105,18 @@ type BranchStatus struct {
 	Primary   bool        `json:"primary"`
 	CreatedAt string      `json:"createdAt"`
 	UpdatedAt string      `json:"updateAt"`
+	// Adopted is true if the branch existed before this resource and was
+	// adopted. Adopted branches are left in Neon when the resource is
+	// deleted.
+	// +optional
+	Adopted bool `json:"adopted,omitempty"`
+	// PendingOperations lists the Neon operations that must finish before
+	// the branch is considered created or deleted.
+	PendingOperations []string `json:"pendingOperations,omitempty"`
+	// Conditions describe the latest observations of the branch. Degraded is
+	// true while the Neon API is unavailable.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 func (bs *BranchStatus) Reset() {
@@ -81,6 +147,25 @@ type Branch struct {
 	Status BranchStatus `json:"status,omitempty"`
 }
 
+// NeonProjectId returns the id of the Neon project of the branch. It is
+// taken from the status when the spec uses ProjectRef.
+func (b *Branch) NeonProjectId() string {
+	if b.Spec.ProjectId != "" {
+		return b.Spec.ProjectId
+	}
+	return b.Status.ProjectId
+}
+
+// NeonParentId returns the id of the parent branch to create the branch
+// from, or "" for the default branch of the project. It is taken from the
+// status when the spec uses ParentRef.
+func (b *Branch) NeonParentId() string {
+	if b.Spec.ParentId != nil {
+		return *b.Spec.ParentId
+	}
+	return b.Status.ParentId
+}
+
 //+kubebuilder:object:root=true
 
 // BranchList contains a list of Branch
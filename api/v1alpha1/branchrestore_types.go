@@ -0,0 +1,128 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BranchRestoreSpec defines the desired state of BranchRestore. A restore
+// runs once; changing the spec afterwards has no effect.
+type BranchRestoreSpec struct {
+	// BranchRef is the name of the Branch resource to restore, in the
+	// namespace of the restore.
+	BranchRef string `json:"branchRef"`
+	// Source is the data the branch is restored to. The branch is reset to
+	// the head of its parent if it is empty.
+	// +optional
+	Source RestoreSource `json:"source,omitempty"`
+	// PreserveUnderName keeps the state of the branch before the restore
+	// as a new branch with this name.
+	// +optional
+	PreserveUnderName string `json:"preserveUnderName,omitempty"`
+
+	// CredentialsRef selects the Secret holding the Neon API key used for
+	// this restore. It must give access to the project of the branch. The
+	// operator's default key is used if it is not set.
+	// +optional
+	CredentialsRef *CredentialsRef `json:"credentialsRef,omitempty"`
+}
+
+// RestoreSource selects the branch and point in time a branch is restored
+// from. Without BranchRef or BranchId the source is the parent of the
+// restored branch, or the restored branch itself when Lsn or Timestamp is
+// set, which rolls it back in time. Restoring a branch from itself requires
+// PreserveUnderName.
+type RestoreSource struct {
+	// BranchRef is the name of a Branch resource to restore from.
+	// +optional
+	BranchRef string `json:"branchRef,omitempty"`
+	// BranchId is the Neon id of a branch to restore from, used instead of
+	// BranchRef.
+	// +optional
+	BranchId string `json:"branchId,omitempty"`
+	// Lsn restores the source as of a Postgres log sequence number.
+	// +optional
+	Lsn *string `json:"lsn,omitempty"`
+	// Timestamp restores the source as of an RFC 3339 time.
+	// +optional
+	Timestamp *string `json:"timestamp,omitempty"`
+}
+
+// BranchRestoreStatus defines the observed state of BranchRestore
+type BranchRestoreStatus struct {
+	State   BranchRestoreState `json:"state"`
+	Message string             `json:"message,omitempty"`
+	// BranchId and ProjectId identify the restored branch.
+	BranchId  string `json:"branchId,omitempty"`
+	ProjectId string `json:"projectId,omitempty"`
+	// SourceBranchId is the branch the data was restored from.
+	SourceBranchId string `json:"sourceBranchId,omitempty"`
+	// Operations lists the Neon operations started by the restore.
+	Operations []string `json:"operations,omitempty"`
+	// PendingOperations lists the operations that have not finished yet.
+	PendingOperations []string `json:"pendingOperations,omitempty"`
+	StartedAt         string   `json:"startedAt,omitempty"`
+	CompletedAt       string   `json:"completedAt,omitempty"`
+	// Conditions describe the latest observations of the restore. Degraded
+	// is true while the Neon API is unavailable.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+func (rs *BranchRestoreStatus) Reset() {
+	rs.Message = ""
+}
+
+type BranchRestoreState string
+
+const (
+	BranchRestoreStatePending   BranchRestoreState = "pending"
+	BranchRestoreStateRestoring BranchRestoreState = "restoring"
+	BranchRestoreStateSucceeded BranchRestoreState = "succeeded"
+	BranchRestoreStateFailed    BranchRestoreState = "failed"
+)
+
+// Done reports whether the restore has finished, successfully or not.
+func (s BranchRestoreState) Done() bool {
+	return s == BranchRestoreStateSucceeded || s == BranchRestoreStateFailed
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// BranchRestore is the Schema for the branchrestores API
+type BranchRestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BranchRestoreSpec   `json:"spec,omitempty"`
+	Status BranchRestoreStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// BranchRestoreList contains a list of BranchRestore
+type BranchRestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BranchRestore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BranchRestore{}, &BranchRestoreList{})
+}
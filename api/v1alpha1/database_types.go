@@ -0,0 +1,130 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DatabaseSpec defines the desired state of Database
+type DatabaseSpec struct {
+	// BranchFrom selects the branch the database is created on, either
+	// through a Branch or Project resource or by Neon ids.
+	BranchFrom BranchFrom `json:"from"`
+	// Name of the database in Postgres. The name of the resource is used if
+	// it is not set. Changing it renames the database.
+	// +optional
+	Name string `json:"name,omitempty"`
+	// OwnerName is the Postgres role that owns the database. It must exist
+	// on the branch.
+	OwnerName string `json:"ownerName"`
+
+	// AdoptionPolicy decides what happens when the database has not been
+	// created by this resource yet but a database with the same name
+	// already exists on the branch. An adopted database is not dropped
+	// along with the resource.
+	// +kubebuilder:default=Adopt
+	// +optional
+	AdoptionPolicy AdoptionPolicy `json:"adoptionPolicy,omitempty"`
+	// ManageAdopted lets the operator rename an adopted database, change its
+	// owner and drop it along with the resource. An adopted database is
+	// otherwise left untouched.
+	// +optional
+	ManageAdopted bool `json:"manageAdopted,omitempty"`
+
+	// CredentialsRef selects the Secret holding the Neon API key used for
+	// this database. It must give access to the project of the branch. The
+	// operator's default key is used if it is not set.
+	// +optional
+	CredentialsRef *CredentialsRef `json:"credentialsRef,omitempty"`
+}
+
+// DatabaseStatus defines the observed state of Database
+type DatabaseStatus struct {
+	State     DatabaseState `json:"state"`
+	Message   string        `json:"message,omitempty"`
+	Id        int64         `json:"id"`
+	Name      string        `json:"name"`
+	OwnerName string        `json:"ownerName"`
+	BranchId  string        `json:"branchId"`
+	ProjectId string        `json:"projectId"`
+	CreatedAt string        `json:"createdAt"`
+	UpdatedAt string        `json:"updateAt"`
+	// Adopted is true if the database existed before this resource and was
+	// adopted. Adopted databases are left in Neon when the resource is
+	// deleted.
+	// +optional
+	Adopted bool `json:"adopted,omitempty"`
+	// PendingOperations lists the Neon operations that must finish before
+	// the database is considered created.
+	PendingOperations []string `json:"pendingOperations,omitempty"`
+	// Conditions describe the latest observations of the database. Degraded
+	// is true while the Neon API is unavailable.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+func (ds *DatabaseStatus) Reset() {
+	ds.Message = ""
+}
+
+type DatabaseState string
+
+const (
+	DatabaseStateCreating DatabaseState = "creating"
+	DatabaseStateCreated  DatabaseState = "created"
+	DatabaseStateDeleting DatabaseState = "deleting"
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// Database is the Schema for the databases API
+type Database struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DatabaseSpec   `json:"spec,omitempty"`
+	Status DatabaseStatus `json:"status,omitempty"`
+}
+
+// NeonName returns the name the database should have in Postgres.
+func (d *Database) NeonName() string {
+	if d.Spec.Name != "" {
+		return d.Spec.Name
+	}
+	return d.Name
+}
+
+// Managed reports whether the operator may change the database in Neon,
+// which it may unless the database was adopted without ManageAdopted.
+func (d *Database) Managed() bool {
+	return !d.Status.Adopted || d.Spec.ManageAdopted
+}
+
+//+kubebuilder:object:root=true
+
+// DatabaseList contains a list of Database
+type DatabaseList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Database `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Database{}, &DatabaseList{})
+}
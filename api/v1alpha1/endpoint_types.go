@@ -40,12 +40,40 @@ type EndpointSpec struct {
 	Disabled              *bool             `json:"disabled,omitempty"`
 	PasswordlessAccess    *bool             `json:"passwordless_access,omitempty"`
 	SuspendTimeoutSeconds *int64            `json:"suspendTimeoutSeconds,omitempty"`
+
+	// DatabaseName is the database named in the connection string written
+	// when IncludeCredentials is set. It defaults to neondb.
+	// +optional
+	DatabaseName string `json:"databaseName,omitempty"`
+	// RoleName is the role whose credentials are written when
+	// IncludeCredentials is set. The first role Neon lists for the branch
+	// is used if it is not set.
+	// +optional
+	RoleName string `json:"roleName,omitempty"`
+
+	// AdoptionPolicy decides what happens when the endpoint has not been
+	// created by this resource yet but an endpoint of the same type already
+	// exists on the branch.
+	// +kubebuilder:default=Adopt
+	// +optional
+	AdoptionPolicy AdoptionPolicy `json:"adoptionPolicy,omitempty"`
+
+	// CredentialsRef selects the Secret holding the Neon API key used for
+	// this endpoint. It must give access to the project of the branch. The
+	// operator's default key is used if it is not set.
+	// +optional
+	CredentialsRef *CredentialsRef `json:"credentialsRef,omitempty"`
 }
 
 type BranchFrom struct {
 	BranchRef string `json:"branchRef,omitempty"`
 	ProjectId string `json:"projectId,omitempty"`
 	BranchId  string `json:"branchId,omitempty"`
+	// ProjectRef is the name of a Project resource in the namespace of the
+	// endpoint, used instead of ProjectId. The endpoint is created on
+	// BranchId, or on the default branch of the project if it is not set.
+	// +optional
+	ProjectRef string `json:"projectRef,omitempty"`
 }
 
 type EndpointType string
@@ -70,6 +98,18 @@ type EndpointStatus struct {
 	PendingState string        `json:"pendingState"`
 	CreatedAt    string        `json:"createdAt"`
 	UpdatedAt    string        `json:"updateAt"`
+	// Adopted is true if the endpoint existed before this resource and was
+	// adopted. Adopted endpoints are left in Neon when the resource is
+	// deleted.
+	// +optional
+	Adopted bool `json:"adopted,omitempty"`
+	// PendingOperations lists the Neon operations that must finish before
+	// the endpoint is considered created or deleted.
+	PendingOperations []string `json:"pendingOperations,omitempty"`
+	// Conditions describe the latest observations of the endpoint. Degraded is
+	// true while the Neon API is unavailable.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 func (es *EndpointStatus) Reset() {
@@ -0,0 +1,145 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ProjectSpec defines the desired state of Project
+type ProjectSpec struct {
+	// Name of the project in Neon. The name of the resource is used if it
+	// is not set.
+	// +optional
+	Name string `json:"name,omitempty"`
+	// RegionId is the region the project is created in, such as
+	// aws-us-east-2. Neon chooses a default region if it is not set.
+	// +optional
+	RegionId string `json:"regionId,omitempty"`
+	// PgVersion is the major Postgres version of the project.
+	// +optional
+	PgVersion int `json:"pgVersion,omitempty"`
+	// HistoryRetentionSeconds is how long the history of the project is
+	// kept for point in time restores and branching.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	HistoryRetentionSeconds *int64 `json:"historyRetentionSeconds,omitempty"`
+	// DefaultEndpointSettings apply to the endpoint created along with the
+	// project and to endpoints created later without their own settings.
+	// +optional
+	DefaultEndpointSettings *DefaultEndpointSettings `json:"defaultEndpointSettings,omitempty"`
+
+	// AdoptionPolicy decides what happens when the project has not been
+	// created by this resource yet but a project with the same name
+	// already exists in the account. An adopted project is not deleted
+	// along with the resource.
+	// +kubebuilder:default=Adopt
+	// +optional
+	AdoptionPolicy AdoptionPolicy `json:"adoptionPolicy,omitempty"`
+
+	// CredentialsRef selects the Secret holding the Neon API key used for
+	// this project. The operator's default key is used if it is not set.
+	// +optional
+	CredentialsRef *CredentialsRef `json:"credentialsRef,omitempty"`
+}
+
+// DefaultEndpointSettings are the compute settings used by the endpoints of
+// a project unless set on an endpoint.
+type DefaultEndpointSettings struct {
+	AutoscalingLimitMinCu *int   `json:"autoscalingLimitMinCu,omitempty"`
+	AutoscalingLimitMaxCu *int   `json:"autoscalingLimitMaxCu,omitempty"`
+	SuspendTimeoutSeconds *int64 `json:"suspendTimeoutSeconds,omitempty"`
+}
+
+// ProjectStatus defines the observed state of Project
+type ProjectStatus struct {
+	State     ProjectState `json:"state"`
+	Message   string       `json:"message,omitempty"`
+	Id        string       `json:"id"`
+	Name      string       `json:"name"`
+	RegionId  string       `json:"regionId"`
+	PgVersion int          `json:"pgVersion"`
+	// DefaultBranchId is the id of the branch created with the project.
+	DefaultBranchId string `json:"defaultBranchId,omitempty"`
+	// DefaultEndpointId and DefaultEndpointHost identify the read-write
+	// endpoint of the default branch.
+	DefaultEndpointId   string `json:"defaultEndpointId,omitempty"`
+	DefaultEndpointHost string `json:"defaultEndpointHost,omitempty"`
+	CreatedAt           string `json:"createdAt"`
+	UpdatedAt           string `json:"updateAt"`
+	// Adopted is true if the project existed before this resource and was
+	// adopted. Adopted projects are left in Neon when the resource is
+	// deleted.
+	// +optional
+	Adopted bool `json:"adopted,omitempty"`
+	// PendingOperations lists the Neon operations that must finish before
+	// the project is considered created.
+	PendingOperations []string `json:"pendingOperations,omitempty"`
+	// Conditions describe the latest observations of the project. Degraded
+	// is true while the Neon API is unavailable.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+func (ps *ProjectStatus) Reset() {
+	ps.Message = ""
+}
+
+type ProjectState string
+
+const (
+	ProjectStateCreating ProjectState = "creating"
+	ProjectStateCreated  ProjectState = "created"
+	ProjectStateDeleting ProjectState = "deleting"
+)
+
+func (p ProjectState) Exists() bool {
+	return p == ProjectStateCreated || p == ProjectStateDeleting
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// Project is the Schema for the projects API
+type Project struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ProjectSpec   `json:"spec,omitempty"`
+	Status ProjectStatus `json:"status,omitempty"`
+}
+
+// NeonName returns the name of the project in Neon.
+func (p *Project) NeonName() string {
+	if p.Spec.Name != "" {
+		return p.Spec.Name
+	}
+	return p.Name
+}
+
+//+kubebuilder:object:root=true
+
+// ProjectList contains a list of Project
+type ProjectList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Project `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Project{}, &ProjectList{})
+}
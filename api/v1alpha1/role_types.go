@@ -0,0 +1,146 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RoleSpec defines the desired state of Role
+type RoleSpec struct {
+	// BranchFrom selects the branch the role is created on, either through
+	// a Branch or Project resource or by Neon ids.
+	BranchFrom BranchFrom `json:"from"`
+	// Name of the role in Postgres. The name of the resource is used if it
+	// is not set. Roles cannot be renamed.
+	// +optional
+	Name string `json:"name,omitempty"`
+	// SecretName is the Secret the username and password of the role are
+	// written to. It defaults to neon-role-<resource name>.
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+	// PasswordRevision resets the password of the role whenever it is
+	// increased. The new password is written to the Secret.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	PasswordRevision int64 `json:"passwordRevision,omitempty"`
+
+	// AdoptionPolicy decides what happens when the role has not been
+	// created by this resource yet but a role with the same name already
+	// exists on the branch.
+	// +kubebuilder:default=Adopt
+	// +optional
+	AdoptionPolicy AdoptionPolicy `json:"adoptionPolicy,omitempty"`
+	// ManageAdopted lets the operator write the credentials of an adopted
+	// role to the Secret, reset its password and delete it along with the
+	// resource. An adopted role is otherwise left untouched.
+	// +optional
+	ManageAdopted bool `json:"manageAdopted,omitempty"`
+
+	// CredentialsRef selects the Secret holding the Neon API key used for
+	// this role. It must give access to the project of the branch. The
+	// operator's default key is used if it is not set.
+	// +optional
+	CredentialsRef *CredentialsRef `json:"credentialsRef,omitempty"`
+}
+
+// RoleStatus defines the observed state of Role
+type RoleStatus struct {
+	State     RoleState `json:"state"`
+	Message   string    `json:"message,omitempty"`
+	Name      string    `json:"name"`
+	BranchId  string    `json:"branchId"`
+	ProjectId string    `json:"projectId"`
+	// SecretName is the Secret holding the credentials of the role.
+	SecretName string `json:"secretName,omitempty"`
+	// PasswordRevision is the revision of the password in the Secret.
+	PasswordRevision int64  `json:"passwordRevision,omitempty"`
+	CreatedAt        string `json:"createdAt"`
+	UpdatedAt        string `json:"updateAt"`
+	// Adopted is true if the role existed before this resource and was
+	// adopted.
+	// +optional
+	Adopted bool `json:"adopted,omitempty"`
+	// PendingOperations lists the Neon operations that must finish before
+	// the role is considered created.
+	PendingOperations []string `json:"pendingOperations,omitempty"`
+	// Conditions describe the latest observations of the role. Degraded is
+	// true while the Neon API is unavailable.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+func (rs *RoleStatus) Reset() {
+	rs.Message = ""
+}
+
+type RoleState string
+
+const (
+	RoleStateCreating RoleState = "creating"
+	RoleStateCreated  RoleState = "created"
+	RoleStateDeleting RoleState = "deleting"
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// Role is the Schema for the roles API
+type Role struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RoleSpec   `json:"spec,omitempty"`
+	Status RoleStatus `json:"status,omitempty"`
+}
+
+// NeonName returns the name of the role in Postgres.
+func (r *Role) NeonName() string {
+	if r.Spec.Name != "" {
+		return r.Spec.Name
+	}
+	return r.Name
+}
+
+// Managed reports whether the operator may change the role in Neon and
+// write its credentials, which it may unless the role was adopted without
+// ManageAdopted.
+func (r *Role) Managed() bool {
+	return !r.Status.Adopted || r.Spec.ManageAdopted
+}
+
+// SecretName returns the name of the Secret holding the credentials of the
+// role.
+func (r *Role) SecretName() string {
+	if r.Spec.SecretName != "" {
+		return r.Spec.SecretName
+	}
+	return "neon-role-" + r.Name
+}
+
+//+kubebuilder:object:root=true
+
+// RoleList contains a list of Role
+type RoleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Role `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Role{}, &RoleList{})
+}
@@ -8,6 +8,9 @@ import (
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	neontechv1alpha1 "github.com/evanshortiss/neon-kube-operator/api/v1alpha1"
+	"github.com/evanshortiss/neon-kube-operator/neon"
 )
 
 func CreateOrUpdate(ctx context.Context, c client.Client, obj client.Object, f controllerutil.MutateFn) (controllerutil.OperationResult, error) {
@@ -47,3 +50,104 @@ func mutate(f controllerutil.MutateFn, key client.ObjectKey, obj client.Object)
 	}
 	return nil
 }
+
+// ownedBranchIds returns the Neon ids recorded by the Branch resources other
+// than branch, in all namespaces. These branches cannot be adopted.
+func ownedBranchIds(ctx context.Context, c client.Client, branch *neontechv1alpha1.Branch) (map[string]bool, error) {
+	var branches neontechv1alpha1.BranchList
+	if err := c.List(ctx, &branches); err != nil {
+		return nil, err
+	}
+	owned := make(map[string]bool)
+	for _, b := range branches.Items {
+		if b.Status.Id != "" && client.ObjectKeyFromObject(&b) != client.ObjectKeyFromObject(branch) {
+			owned[b.Status.Id] = true
+		}
+	}
+	return owned, nil
+}
+
+// ownedEndpointIds returns the Neon ids recorded by the Endpoint resources
+// other than endpoint, in all namespaces. These endpoints cannot be adopted.
+func ownedEndpointIds(ctx context.Context, c client.Client, endpoint *neontechv1alpha1.Endpoint) (map[string]bool, error) {
+	var endpoints neontechv1alpha1.EndpointList
+	if err := c.List(ctx, &endpoints); err != nil {
+		return nil, err
+	}
+	owned := make(map[string]bool)
+	for _, e := range endpoints.Items {
+		if e.Status.Id != "" && client.ObjectKeyFromObject(&e) != client.ObjectKeyFromObject(endpoint) {
+			owned[e.Status.Id] = true
+		}
+	}
+	return owned, nil
+}
+
+// ownedProjectIds returns the Neon ids recorded by the Project resources
+// other than project, in all namespaces. These projects cannot be adopted.
+func ownedProjectIds(ctx context.Context, c client.Client, project *neontechv1alpha1.Project) (map[string]bool, error) {
+	var projects neontechv1alpha1.ProjectList
+	if err := c.List(ctx, &projects); err != nil {
+		return nil, err
+	}
+	owned := make(map[string]bool)
+	for _, p := range projects.Items {
+		if p.Status.Id != "" && client.ObjectKeyFromObject(&p) != client.ObjectKeyFromObject(project) {
+			owned[p.Status.Id] = true
+		}
+	}
+	return owned, nil
+}
+
+// ownedDatabaseNames returns the databases recorded by the Database
+// resources other than database, in all namespaces, as branch id and name
+// joined by a slash. These databases cannot be adopted.
+func ownedDatabaseNames(ctx context.Context, c client.Client, database *neontechv1alpha1.Database) (map[string]bool, error) {
+	var databases neontechv1alpha1.DatabaseList
+	if err := c.List(ctx, &databases); err != nil {
+		return nil, err
+	}
+	owned := make(map[string]bool)
+	for _, d := range databases.Items {
+		if d.Status.Name != "" && client.ObjectKeyFromObject(&d) != client.ObjectKeyFromObject(database) {
+			owned[d.Status.BranchId+"/"+d.Status.Name] = true
+		}
+	}
+	return owned, nil
+}
+
+// ownedRoleNames returns the roles recorded by the Role resources other than
+// role, in all namespaces, as branch id and name joined by a slash. These
+// roles cannot be adopted.
+func ownedRoleNames(ctx context.Context, c client.Client, role *neontechv1alpha1.Role) (map[string]bool, error) {
+	var roles neontechv1alpha1.RoleList
+	if err := c.List(ctx, &roles); err != nil {
+		return nil, err
+	}
+	owned := make(map[string]bool)
+	for _, r := range roles.Items {
+		if r.Status.Name != "" && client.ObjectKeyFromObject(&r) != client.ObjectKeyFromObject(role) {
+			owned[r.Status.BranchId+"/"+r.Status.Name] = true
+		}
+	}
+	return owned, nil
+}
+
+// resolveBranchOnce sets branchId and projectId, usually fields of a status,
+// to the branch selected by from unless branchId is already set. Objects
+// that live on a branch, such as databases and roles, cannot move to
+// another one.
+func resolveBranchOnce(ctx context.Context, c client.Client, namespace string, from neontechv1alpha1.BranchFrom, branchId, projectId *string) error {
+	if *branchId != "" {
+		return nil
+	}
+	b, p, err := neon.ResolveBranchFrom(ctx, c, namespace, from)
+	if err != nil {
+		return err
+	}
+	if b == "" || p == "" {
+		return fmt.Errorf("the branch is not set, from needs a branchRef, projectRef or projectId and branchId")
+	}
+	*branchId, *projectId = b, p
+	return nil
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/evanshortiss/neon-kube-operator/neon"
+	"github.com/evanshortiss/neon-kube-operator/neon/redact"
+)
+
+// AuditLog writes the audit events of Neon API calls as JSON lines, in
+// addition to the Events recorded on the resources.
+type AuditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewAuditLog returns an AuditLog writing to w.
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{w: w}
+}
+
+type auditLogEntry struct {
+	neon.AuditEvent
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	UID       string `json:"uid"`
+}
+
+func (l *AuditLog) write(kind string, obj client.Object, event neon.AuditEvent) error {
+	data, err := json.Marshal(auditLogEntry{
+		AuditEvent: event,
+		Kind:       kind,
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+		UID:        string(obj.GetUID()),
+	})
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(append(data, '\n'))
+	return err
+}
+
+// objectAuditor records the Neon API calls made while reconciling an object
+// as Events on it.
+type objectAuditor struct {
+	recorder record.EventRecorder
+	log      *AuditLog
+	kind     string
+	object   client.Object
+}
+
+// withAuditor returns a context whose Neon API calls are recorded on obj
+// of the given kind. recorder and auditLog may be nil.
+func withAuditor(ctx context.Context, recorder record.EventRecorder, auditLog *AuditLog, kind string, obj client.Object) context.Context {
+	if recorder == nil && auditLog == nil {
+		return ctx
+	}
+	return neon.ContextWithAuditor(ctx, &objectAuditor{
+		recorder: recorder,
+		log:      auditLog,
+		kind:     kind,
+		object:   obj,
+	})
+}
+
+func (a *objectAuditor) Audit(ctx context.Context, event neon.AuditEvent) {
+	if a.recorder != nil {
+		eventType, outcome := v1.EventTypeNormal, "succeeded"
+		if !event.Succeeded() {
+			eventType, outcome = v1.EventTypeWarning, "failed: "+event.Error
+		}
+		msg := fmt.Sprintf("Neon %s %s %s", event.Method, event.Route, outcome)
+		if len(event.OperationIds) > 0 {
+			msg += ", operations " + strings.Join(event.OperationIds, ", ")
+		}
+		a.recorder.Event(a.object, eventType, auditReason(event.Action), redact.String(msg))
+	}
+	if a.log != nil {
+		if err := a.log.write(a.kind, a.object, event); err != nil {
+			log.FromContext(ctx).Error(err, "failed to write audit log")
+		}
+	}
+}
+
+// auditReason turns an action such as reveal_password into the Event
+// reason NeonRevealPassword.
+func auditReason(action string) string {
+	reason := "Neon"
+	for _, word := range strings.Split(action, "_") {
+		if word != "" {
+			reason += strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return reason
+}
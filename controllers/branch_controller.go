@@ -19,9 +19,13 @@ package controllers
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
 
+	"go.opentelemetry.io/otel/trace"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -40,13 +44,21 @@ type BranchReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
 
-	NeonClient *neon.Client
+	// NeonClients provides the Neon client for the credentials a branch
+	// references.
+	NeonClients neon.ClientProvider
+
+	// Recorder records Neon API calls made for a branch as Events on it.
+	Recorder record.EventRecorder
+	// AuditLog, if set, also receives the calls as JSON lines.
+	AuditLog *AuditLog
 }
 
 //+kubebuilder:rbac:groups=neon.tech,resources=branches,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=neon.tech,resources=branches/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=neon.tech,resources=branches/finalizers,verbs=update
 //+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -58,6 +70,14 @@ type BranchReconciler struct {
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.14.1/pkg/reconcile
 func (r *BranchReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	ctx, span := startReconcileSpan(ctx, "Branch", req)
+	result, err := r.reconcileRequest(ctx, req)
+	endReconcileSpan(span, result, err)
+	return result, err
+}
+
+// reconcileRequest does the work of Reconcile within its trace span.
+func (r *BranchReconciler) reconcileRequest(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
 
 	b := &neontechv1alpha1.Branch{}
@@ -69,13 +89,26 @@ func (r *BranchReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 		}
 		return ctrl.Result{}, err
 	}
+	trace.SpanFromContext(ctx).SetAttributes(
+		neon.ProjectIdKey.String(b.NeonProjectId()),
+		neon.BranchIdKey.String(b.Status.Id),
+	)
+	ctx = withAuditor(ctx, r.Recorder, r.AuditLog, "Branch", b)
 	if err = AddFinalizer(ctx, r.Client, b); err != nil {
 		return ctrl.Result{}, err
 	}
 
 	if b.DeletionTimestamp != nil {
+		if b.Status.State != neontechv1alpha1.BranchStateDeleting {
+			// Operations started by the creation no longer need to be
+			// waited on, the deletion tracks its own operations.
+			b.Status.PendingOperations = nil
+		}
 		_ = r.updateState(ctx, b, neontechv1alpha1.BranchStateDeleting)
 		if err := r.ExecuteFinalizer(ctx, b); err != nil {
+			if errors.Is(err, neon.ErrRetryAgain) {
+				return ctrl.Result{Requeue: true, RequeueAfter: 5 * time.Second}, nil
+			}
 			return ctrl.Result{}, err
 		}
 		return ctrl.Result{}, nil
@@ -83,10 +116,11 @@ func (r *BranchReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 
 	err = r.reconcile(ctx, b)
 	if err != nil {
-		b.Status.Message = err.Error()
+		b.Status.Message = neon.ErrorMessage(err)
 	} else {
 		b.Status.Reset()
 	}
+	setDegraded(&b.Status.Conditions, b.Generation, err)
 
 	tries := 0
 	for tries < 5 {
@@ -98,19 +132,25 @@ func (r *BranchReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 		if tries == 4 {
 			return ctrl.Result{}, updateErr
 		}
+		tries++
 	}
 
+	if errors.Is(err, neon.ErrRetryAgain) {
+		return ctrl.Result{Requeue: true, RequeueAfter: 5 * time.Second}, nil
+	}
 	return ctrl.Result{}, err
 }
 
+// ExecuteFinalizer deletes the branch in Neon unless it was adopted or never
+// created, then removes the finalizer.
 func (r *BranchReconciler) ExecuteFinalizer(ctx context.Context, branch *neontechv1alpha1.Branch) error {
 	logger := log.FromContext(ctx)
-	if branch.Status.Id == "" {
-		return nil
-	}
-	logger.Info("Reconciling deletion of branch", "name", branch.Name)
-	if _, err := r.NeonClient.DeleteBranch(ctx, branch); err != nil {
-		return err
+	if branch.Status.Adopted {
+		logger.Info("Leaving adopted branch in Neon", "name", branch.Name, "id", branch.Status.Id)
+	} else if branch.Status.Id != "" {
+		if err := r.deleteBranch(ctx, branch); err != nil {
+			return err
+		}
 	}
 	if ok := controllerutil.RemoveFinalizer(branch, neonFinalizer); ok {
 		if err := r.Update(ctx, branch); err != nil {
@@ -121,9 +161,59 @@ func (r *BranchReconciler) ExecuteFinalizer(ctx context.Context, branch *neontec
 	return nil
 }
 
+// deleteBranch deletes the branch in Neon and waits for the operations of
+// the deletion to finish.
+func (r *BranchReconciler) deleteBranch(ctx context.Context, branch *neontechv1alpha1.Branch) error {
+	log.FromContext(ctx).Info("Reconciling deletion of branch", "name", branch.Name)
+	neonClient, err := r.NeonClients.ClientFor(ctx, branch.Namespace, branch.Spec.CredentialsRef)
+	if err != nil {
+		return err
+	}
+	operations := branch.Status.PendingOperations
+	if len(operations) == 0 {
+		resp, err := neonClient.DeleteBranch(ctx, branch)
+		if err != nil {
+			return err
+		}
+		if resp != nil {
+			operations = neon.OperationIds(resp.Operations)
+		}
+	}
+	pending, err := neonClient.PendingOperations(ctx, branch.NeonProjectId(), operations)
+	if len(pending) > 0 || err != nil {
+		branch.Status.PendingOperations = pending
+		if updateErr := r.Status().Update(ctx, branch); updateErr != nil {
+			return updateErr
+		}
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("waiting for %d operations to finish, %w", len(pending), neon.ErrRetryAgain)
+	}
+	return nil
+}
+
 func (r *BranchReconciler) reconcile(ctx context.Context, branch *neontechv1alpha1.Branch) error {
-	logger := log.FromContext(ctx)
-	resp, err := r.NeonClient.GetBranch(ctx, branch)
+	neonClient, err := r.NeonClients.ClientFor(ctx, branch.Namespace, branch.Spec.CredentialsRef)
+	if err != nil {
+		return err
+	}
+	if branch.NeonProjectId() == "" && branch.Spec.ProjectRef != "" {
+		project, err := neon.GetProjectRef(ctx, r.Client, branch.Namespace, branch.Spec.ProjectRef)
+		if err != nil {
+			return err
+		}
+		branch.Status.ProjectId = project.Status.Id
+	}
+	if err := r.resolveParentRef(ctx, branch); err != nil {
+		return err
+	}
+	if branch.NeonProjectId() == "" {
+		return errors.New("either projectId, projectRef or parentRef must be set")
+	}
+	operations := branch.Status.PendingOperations
+	adopted := branch.Status.Adopted
+	resp, err := neonClient.GetBranch(ctx, branch)
 	shouldCreate := false
 	if err != nil {
 		if !errors.Is(err, neon.ErrBranchNotFound) {
@@ -132,21 +222,82 @@ func (r *BranchReconciler) reconcile(ctx context.Context, branch *neontechv1alph
 
 		shouldCreate = true
 	}
-	if !shouldCreate {
-		branch.Status = neon.NewBranchStatus(resp)
-		branch.Status.State = neontechv1alpha1.BranchStateCreated
+	if shouldCreate {
+		resp, adopted, err = r.adoptOrCreate(ctx, neonClient, branch)
+		if err != nil {
+			return err
+		}
+		operations = neon.OperationIds(resp.Operations)
+	}
+	conditions := branch.Status.Conditions
+	branch.Status = neon.NewBranchStatus(resp.Branch)
+	branch.Status.Conditions = conditions
+	branch.Status.Adopted = adopted
+
+	pending, err := neonClient.PendingOperations(ctx, branch.Status.ProjectId, operations)
+	branch.Status.PendingOperations = pending
+	if err != nil || len(pending) > 0 {
+		branch.Status.State = neontechv1alpha1.BranchStateCreating
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("waiting for %d operations to finish, %w", len(pending), neon.ErrRetryAgain)
+	}
+	branch.Status.State = neontechv1alpha1.BranchStateCreated
+	return nil
+}
+
+// resolveParentRef records the id of the parent branch referenced by
+// ParentRef in the status, and takes the project from the parent if the
+// branch names none. It waits for the parent to exist in Neon, and only
+// resolves the parent until the branch is created.
+func (r *BranchReconciler) resolveParentRef(ctx context.Context, branch *neontechv1alpha1.Branch) error {
+	if branch.Spec.ParentRef == "" || branch.Status.Id != "" || branch.Status.ParentId != "" {
 		return nil
 	}
-	logger.Info("Creating branch", "name", branch.Name)
-	resp, err = r.NeonClient.CreateBranch(ctx, branch)
+	if branch.Spec.ParentId != nil {
+		return errors.New("only one of parentId and parentRef can be set")
+	}
+	parent, err := neon.GetBranchRef(ctx, r.Client, branch.Namespace, branch.Spec.ParentRef)
 	if err != nil {
 		return err
 	}
-	branch.Status = neon.NewBranchStatus(resp)
-	branch.Status.State = neontechv1alpha1.BranchStateCreated
+	projectId := parent.NeonProjectId()
+	if branch.NeonProjectId() != "" && branch.NeonProjectId() != projectId {
+		return fmt.Errorf("parent branch %s is in project %s, not %s", parent.Name, projectId, branch.NeonProjectId())
+	}
+	branch.Status.ProjectId = projectId
+	branch.Status.ParentId = parent.Status.Id
 	return nil
 }
 
+// adoptOrCreate creates the branch in Neon. A branch that has never been
+// created by this resource first applies the adoption policy to an existing
+// branch with the same name, so that a lost status does not lead to a
+// duplicate branch. It reports whether the branch was adopted.
+func (r *BranchReconciler) adoptOrCreate(ctx context.Context, neonClient neon.BranchClient, branch *neontechv1alpha1.Branch) (*neon.BranchResponse, bool, error) {
+	logger := log.FromContext(ctx)
+	if branch.Status.Id == "" && branch.Spec.AdoptionPolicy != neontechv1alpha1.AdoptionPolicyCreate {
+		owned, err := ownedBranchIds(ctx, r.Client, branch)
+		if err != nil {
+			return nil, false, err
+		}
+		existing, err := neonClient.FindBranch(ctx, branch, owned)
+		switch {
+		case err == nil && branch.Spec.AdoptionPolicy == neontechv1alpha1.AdoptionPolicyFail:
+			return nil, false, fmt.Errorf("branch %s already exists in project %s", existing.Name, existing.ProjectId)
+		case err == nil:
+			logger.Info("Adopting existing branch", "name", branch.Name, "id", existing.Id)
+			return &neon.BranchResponse{Branch: *existing}, true, nil
+		case !errors.Is(err, neon.ErrBranchNotFound):
+			return nil, false, err
+		}
+	}
+	logger.Info("Creating branch", "name", branch.Name)
+	resp, err := neonClient.CreateBranch(ctx, branch)
+	return resp, false, err
+}
+
 func AddFinalizer(ctx context.Context, c client.Client, object client.Object) error {
 	logger := log.FromContext(ctx)
 	if !controllerutil.ContainsFinalizer(object, neonFinalizer) && object.GetDeletionTimestamp() == nil {
@@ -0,0 +1,332 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	neontechv1alpha1 "github.com/evanshortiss/neon-kube-operator/api/v1alpha1"
+	"github.com/evanshortiss/neon-kube-operator/neon"
+	"github.com/evanshortiss/neon-kube-operator/neon/neontest"
+)
+
+func TestBranchParentRef(t *testing.T) {
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("app")
+	dev := &neontechv1alpha1.Branch{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "default"},
+		Spec:       neontechv1alpha1.BranchSpec{ProjectId: projectId},
+	}
+	feature := &neontechv1alpha1.Branch{
+		ObjectMeta: metav1.ObjectMeta{Name: "feature", Namespace: "default"},
+		Spec:       neontechv1alpha1.BranchSpec{ParentRef: "dev"},
+	}
+	scheme := newTestScheme()
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(dev, feature).Build()
+	r := &BranchReconciler{
+		Client:      k8sClient,
+		Scheme:      scheme,
+		NeonClients: neon.NewClientPool(k8sClient, server.Client(), nil),
+	}
+	ctx := context.Background()
+
+	// The child waits until its parent has been created.
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(feature)})
+	if err != nil || result.RequeueAfter == 0 {
+		t.Fatalf("Reconcile before the parent exists = %+v, %v, want a requeue", result, err)
+	}
+	if n := len(server.Branches(projectId)); n != 1 {
+		t.Fatalf("%d branches exist before the parent is created, want only the primary", n)
+	}
+
+	reconcileUntilDone(t, r, dev)
+	reconcileUntilDone(t, r, feature)
+	for _, obj := range []*neontechv1alpha1.Branch{dev, feature} {
+		if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if feature.Status.State != neontechv1alpha1.BranchStateCreated || feature.Status.ProjectId != projectId {
+		t.Fatalf("unexpected child status %+v", feature.Status)
+	}
+	created, ok := server.Branch(projectId, feature.Status.Id)
+	if !ok {
+		t.Fatalf("branch %s not found in Neon", feature.Status.Id)
+	}
+	if created.ParentId != dev.Status.Id {
+		t.Errorf("branch was created from %s, want %s", created.ParentId, dev.Status.Id)
+	}
+}
+
+func TestBranchOperationFailed(t *testing.T) {
+	server := neontest.NewServer(t, neontest.WithOperationDuration(time.Hour))
+	projectId := server.AddProject("app")
+	branch := &neontechv1alpha1.Branch{
+		ObjectMeta: metav1.ObjectMeta{Name: "feature", Namespace: "default"},
+		Spec:       neontechv1alpha1.BranchSpec{ProjectId: projectId},
+	}
+	scheme := newTestScheme()
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(branch).Build()
+	r := &BranchReconciler{
+		Client:      k8sClient,
+		Scheme:      scheme,
+		NeonClients: neon.NewClientPool(k8sClient, server.Client(), nil),
+	}
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(branch)}
+	// failLatest fails the last operation Neon started.
+	failLatest := func() {
+		ops := server.Operations()
+		server.SetOperationStatus(ops[len(ops)-1].Id, neon.OperationStatusFailed)
+	}
+
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	failLatest()
+	// The failure is reported on every reconcile, not only the first one
+	// after it happened.
+	for i := 0; i < 2; i++ {
+		if _, err := r.Reconcile(ctx, req); err == nil {
+			t.Fatalf("Reconcile %d after the operation failed succeeded", i)
+		}
+		if err := k8sClient.Get(ctx, req.NamespacedName, branch); err != nil {
+			t.Fatal(err)
+		}
+		if branch.Status.State != neontechv1alpha1.BranchStateCreating || len(branch.Status.PendingOperations) != 1 {
+			t.Fatalf("unexpected status after reconcile %d: %+v", i, branch.Status)
+		}
+	}
+
+	if err := k8sClient.Delete(ctx, branch); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	failLatest()
+	for i := 0; i < 2; i++ {
+		if _, err := r.Reconcile(ctx, req); err == nil {
+			t.Fatalf("Reconcile %d after the deletion failed succeeded", i)
+		}
+		if err := k8sClient.Get(ctx, req.NamespacedName, branch); err != nil {
+			t.Fatalf("branch was released after the deletion failed: %v", err)
+		}
+	}
+	server.ExpectRequests(t, "DELETE", "/projects/*/branches/*", 1)
+}
+
+func TestBranchFinalizedAfterSecretDeletion(t *testing.T) {
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("app")
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "team-a", Namespace: "default"},
+		Data:       map[string][]byte{neon.DefaultCredentialsKey: []byte("key-a")},
+	}
+	branch := &neontechv1alpha1.Branch{
+		ObjectMeta: metav1.ObjectMeta{Name: "feature", Namespace: "default"},
+		Spec: neontechv1alpha1.BranchSpec{
+			ProjectId:      projectId,
+			CredentialsRef: &neontechv1alpha1.CredentialsRef{Name: "team-a"},
+		},
+	}
+	scheme := newTestScheme()
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(secret, branch).Build()
+	pool := neon.NewClientPool(k8sClient, nil, func(string) neon.API { return server.Client() })
+	r := &BranchReconciler{
+		Client:      k8sClient,
+		Scheme:      scheme,
+		NeonClients: pool,
+	}
+	ctx := context.Background()
+
+	reconcileUntilDone(t, r, branch)
+	if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(branch), branch); err != nil {
+		t.Fatal(err)
+	}
+	branchId := branch.Status.Id
+
+	// The namespace is deleted, taking the Secret with it before the branch
+	// is finalized. The idle client is kept for the branch.
+	if err := k8sClient.Delete(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Delete(ctx, branch); err != nil {
+		t.Fatal(err)
+	}
+	pool.IdleTimeout = 0
+	pool.Prune(ctx)
+
+	reconcileUntilDone(t, r, branch)
+	if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(branch), branch); !kerrors.IsNotFound(err) {
+		t.Fatalf("expected the branch to be finalized, got %v", err)
+	}
+	if _, ok := server.Branch(projectId, branchId); ok {
+		t.Error("branch was not deleted in Neon")
+	}
+
+	// Nothing references the Secret anymore, so its client is dropped.
+	pool.Prune(ctx)
+	if _, err := pool.ClientFor(ctx, "default", branch.Spec.CredentialsRef); !errors.Is(err, neon.ErrRetryAgain) {
+		t.Errorf("expected the client to be dropped, got %v", err)
+	}
+}
+
+func TestAdoptedBranchIsNotDeleted(t *testing.T) {
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("app")
+	existing, err := server.AddBranch(projectId, "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var primary neon.Branch
+	for _, b := range server.Branches(projectId) {
+		if b.Primary {
+			primary = b
+		}
+	}
+	dev := &neontechv1alpha1.Branch{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "default"},
+		Spec:       neontechv1alpha1.BranchSpec{ProjectId: projectId},
+	}
+	named := &neontechv1alpha1.Branch{
+		ObjectMeta: metav1.ObjectMeta{Name: primary.Name, Namespace: "default"},
+		Spec:       neontechv1alpha1.BranchSpec{ProjectId: projectId},
+	}
+	scheme := newTestScheme()
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(dev, named).Build()
+	r := &BranchReconciler{
+		Client:      k8sClient,
+		Scheme:      scheme,
+		NeonClients: neon.NewClientPool(k8sClient, server.Client(), nil),
+	}
+	ctx := context.Background()
+
+	reconcileUntilDone(t, r, dev)
+	reconcileUntilDone(t, r, named)
+	for _, obj := range []*neontechv1alpha1.Branch{dev, named} {
+		if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dev.Status.Id != existing.Id || !dev.Status.Adopted {
+		t.Errorf("unexpected status of the adopted branch %+v", dev.Status)
+	}
+	// The primary branch is never adopted, even by a resource named after it.
+	if named.Status.Id == primary.Id || named.Status.Adopted {
+		t.Errorf("unexpected status of the branch named after the primary branch %+v", named.Status)
+	}
+
+	for _, obj := range []*neontechv1alpha1.Branch{dev, named} {
+		if err := k8sClient.Delete(ctx, obj); err != nil {
+			t.Fatal(err)
+		}
+		reconcileUntilDone(t, r, obj)
+		if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(obj), obj); !kerrors.IsNotFound(err) {
+			t.Fatalf("expected branch %s to be finalized, got %v", obj.Name, err)
+		}
+	}
+	if _, ok := server.Branch(projectId, existing.Id); !ok {
+		t.Error("the adopted branch was deleted in Neon")
+	}
+	if _, ok := server.Branch(projectId, named.Status.Id); ok {
+		t.Error("the created branch was not deleted in Neon")
+	}
+	server.ExpectRequests(t, "DELETE", "/projects/*/branches/*", 1)
+}
+
+func TestBranchWithoutIdIsFinalized(t *testing.T) {
+	server := neontest.NewServer(t)
+	// The branch is never created, since it names no project.
+	branch := &neontechv1alpha1.Branch{
+		ObjectMeta: metav1.ObjectMeta{Name: "feature", Namespace: "default"},
+	}
+	scheme := newTestScheme()
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(branch).Build()
+	r := &BranchReconciler{
+		Client:      k8sClient,
+		Scheme:      scheme,
+		NeonClients: neon.NewClientPool(k8sClient, server.Client(), nil),
+	}
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(branch)}
+
+	if _, err := r.Reconcile(ctx, req); err == nil {
+		t.Fatal("expected Reconcile to fail without a project")
+	}
+	if err := k8sClient.Get(ctx, req.NamespacedName, branch); err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Delete(ctx, branch); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Get(ctx, req.NamespacedName, branch); !kerrors.IsNotFound(err) {
+		t.Errorf("expected the branch to be finalized, got %v", err)
+	}
+}
+
+func TestBranchDoesNotAdoptOwnedBranch(t *testing.T) {
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("app")
+	existing, err := server.AddBranch(projectId, "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := &neontechv1alpha1.Branch{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "team-a"},
+		Spec:       neontechv1alpha1.BranchSpec{ProjectId: projectId},
+	}
+	second := &neontechv1alpha1.Branch{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "team-b"},
+		Spec:       neontechv1alpha1.BranchSpec{ProjectId: projectId},
+	}
+	scheme := newTestScheme()
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(first, second).Build()
+	r := &BranchReconciler{
+		Client:      k8sClient,
+		Scheme:      scheme,
+		NeonClients: neon.NewClientPool(k8sClient, server.Client(), nil),
+	}
+	ctx := context.Background()
+
+	reconcileUntilDone(t, r, first)
+	reconcileUntilDone(t, r, second)
+	for _, obj := range []*neontechv1alpha1.Branch{first, second} {
+		if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if first.Status.Id != existing.Id {
+		t.Errorf("first branch has id %s, want the adopted %s", first.Status.Id, existing.Id)
+	}
+	if second.Status.Id == "" || second.Status.Id == existing.Id {
+		t.Errorf("second branch has id %q, want a new branch", second.Status.Id)
+	}
+}
@@ -0,0 +1,248 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neontechv1alpha1 "github.com/evanshortiss/neon-kube-operator/api/v1alpha1"
+	"github.com/evanshortiss/neon-kube-operator/neon"
+)
+
+// BranchRestoreReconciler reconciles a BranchRestore object
+type BranchRestoreReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// NeonClients provides the Neon client for the credentials a restore
+	// references.
+	NeonClients neon.ClientProvider
+
+	// Recorder records Neon API calls made for a restore as Events on it.
+	Recorder record.EventRecorder
+	// AuditLog, if set, also receives the calls as JSON lines.
+	AuditLog *AuditLog
+}
+
+//+kubebuilder:rbac:groups=neon.tech,resources=branchrestores,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=neon.tech,resources=branchrestores/status,verbs=get;update;patch
+
+// Reconcile restores the branch of a BranchRestore resource once, and
+// follows the Neon operations of the restore until they finish. Nothing is
+// undone when the resource is deleted, so it has no finalizer.
+func (r *BranchRestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	ctx, span := startReconcileSpan(ctx, "BranchRestore", req)
+	result, err := r.reconcileRequest(ctx, req)
+	endReconcileSpan(span, result, err)
+	return result, err
+}
+
+// reconcileRequest does the work of Reconcile within its trace span.
+func (r *BranchRestoreReconciler) reconcileRequest(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	restore := &neontechv1alpha1.BranchRestore{}
+	err := r.Client.Get(ctx, req.NamespacedName, restore)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			logger.Info("branch restore resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if restore.DeletionTimestamp != nil || restore.Status.State.Done() {
+		return ctrl.Result{}, nil
+	}
+	trace.SpanFromContext(ctx).SetAttributes(
+		neon.ProjectIdKey.String(restore.Status.ProjectId),
+		neon.BranchIdKey.String(restore.Status.BranchId),
+	)
+	ctx = withAuditor(ctx, r.Recorder, r.AuditLog, "BranchRestore", restore)
+
+	err = r.reconcile(ctx, restore)
+	var failed *neon.OperationFailedError
+	var notRestored *restoreFailedError
+	switch {
+	case errors.As(err, &failed) || errors.As(err, &notRestored):
+		// The restore is not retried, since a new attempt could restore
+		// different data than was asked for.
+		restore.Status.State = neontechv1alpha1.BranchRestoreStateFailed
+		restore.Status.CompletedAt = time.Now().UTC().Format(time.RFC3339)
+		restore.Status.Message = neon.ErrorMessage(err)
+		err = nil
+	case err != nil:
+		restore.Status.Message = neon.ErrorMessage(err)
+	default:
+		restore.Status.Reset()
+	}
+	setDegraded(&restore.Status.Conditions, restore.Generation, err)
+
+	if updateErr := r.Status().Update(ctx, restore); updateErr != nil {
+		return ctrl.Result{}, updateErr
+	}
+
+	if errors.Is(err, neon.ErrRetryAgain) {
+		return ctrl.Result{Requeue: true, RequeueAfter: 5 * time.Second}, nil
+	}
+	return ctrl.Result{}, err
+}
+
+func (r *BranchRestoreReconciler) reconcile(ctx context.Context, restore *neontechv1alpha1.BranchRestore) error {
+	neonClient, err := r.NeonClients.ClientFor(ctx, restore.Namespace, restore.Spec.CredentialsRef)
+	if err != nil {
+		return err
+	}
+	switch {
+	case restore.Status.State != neontechv1alpha1.BranchRestoreStateRestoring:
+		if err := r.start(ctx, neonClient, restore); err != nil {
+			return err
+		}
+	case len(restore.Status.Operations) == 0:
+		// Neon may have been asked to restore, but the operations it started
+		// were not recorded. The restore is never requested again.
+		return &restoreFailedError{fmt.Errorf("the outcome of restoring branch %s is unknown, check the branch and create a new BranchRestore to try again", restore.Status.BranchId)}
+	}
+
+	pending, err := neonClient.PendingOperations(ctx, restore.Status.ProjectId, restore.Status.PendingOperations)
+	restore.Status.PendingOperations = pending
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("waiting for %d operations to finish, %w", len(pending), neon.ErrRetryAgain)
+	}
+	restore.Status.State = neontechv1alpha1.BranchRestoreStateSucceeded
+	restore.Status.CompletedAt = time.Now().UTC().Format(time.RFC3339)
+	return nil
+}
+
+// start resolves the branches of the restore and asks Neon to restore. The
+// restore is pending until the branches it references exist in Neon.
+func (r *BranchRestoreReconciler) start(ctx context.Context, neonClient neon.BranchClient, restore *neontechv1alpha1.BranchRestore) error {
+	logger := log.FromContext(ctx)
+	restore.Status.State = neontechv1alpha1.BranchRestoreStatePending
+	source := restore.Spec.Source
+	if source.Lsn != nil && source.Timestamp != nil {
+		return errors.New("only one of lsn and timestamp can be set")
+	}
+	if source.BranchRef != "" && source.BranchId != "" {
+		return errors.New("only one of branchRef and branchId can be set")
+	}
+
+	target, err := neon.GetBranchRef(ctx, r.Client, restore.Namespace, restore.Spec.BranchRef)
+	if err != nil {
+		return err
+	}
+	restore.Status.BranchId = target.Status.Id
+	restore.Status.ProjectId = target.NeonProjectId()
+
+	switch {
+	case source.BranchRef != "":
+		sourceBranch, err := neon.GetBranchRef(ctx, r.Client, restore.Namespace, source.BranchRef)
+		if err != nil {
+			return err
+		}
+		if sourceBranch.NeonProjectId() != restore.Status.ProjectId {
+			return fmt.Errorf("source branch %s is in project %s, not %s", sourceBranch.Name, sourceBranch.NeonProjectId(), restore.Status.ProjectId)
+		}
+		restore.Status.SourceBranchId = sourceBranch.Status.Id
+	case source.BranchId != "":
+		restore.Status.SourceBranchId = source.BranchId
+	case source.Lsn != nil || source.Timestamp != nil:
+		restore.Status.SourceBranchId = target.Status.Id
+	default:
+		if target.Status.ParentId == "" {
+			return fmt.Errorf("branch %s has no parent to restore from", target.Name)
+		}
+		restore.Status.SourceBranchId = target.Status.ParentId
+	}
+	if restore.Status.SourceBranchId == target.Status.Id {
+		if source.Lsn == nil && source.Timestamp == nil {
+			return fmt.Errorf("branch %s can only be restored from itself to an lsn or timestamp", target.Name)
+		}
+		if restore.Spec.PreserveUnderName == "" {
+			return fmt.Errorf("restoring branch %s from itself requires preserveUnderName", target.Name)
+		}
+	}
+
+	// The restore is recorded as started before Neon is asked, so that a
+	// failed status update or a restart never restores the branch twice.
+	restore.Status.State = neontechv1alpha1.BranchRestoreStateRestoring
+	restore.Status.StartedAt = time.Now().UTC().Format(time.RFC3339)
+	restore.Status.Operations = nil
+	if err := r.Status().Update(ctx, restore); err != nil {
+		restore.Status.State = neontechv1alpha1.BranchRestoreStatePending
+		restore.Status.StartedAt = ""
+		return err
+	}
+
+	logger.Info("Restoring branch", "name", restore.Name, "branch", restore.Status.BranchId, "source", restore.Status.SourceBranchId)
+	resp, err := neonClient.RestoreBranch(ctx, restore)
+	if neon.IsNotSent(err) {
+		// Neon never received the request, so the restore can be started
+		// again once the API is usable.
+		restore.Status.State = neontechv1alpha1.BranchRestoreStatePending
+		restore.Status.StartedAt = ""
+		return err
+	}
+	var apiErr *neon.APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode < http.StatusInternalServerError {
+		// Neon refused the restore, so the branch is unchanged.
+		return &restoreFailedError{err}
+	}
+	if err != nil {
+		return &restoreFailedError{fmt.Errorf("the outcome of restoring branch %s is unknown, check the branch and create a new BranchRestore to try again: %w", restore.Status.BranchId, err)}
+	}
+	for _, op := range resp.Operations {
+		restore.Status.Operations = append(restore.Status.Operations, op.Id)
+	}
+	restore.Status.PendingOperations = neon.OperationIds(resp.Operations)
+	return nil
+}
+
+// restoreFailedError reports a restore that ended without Neon restoring
+// the branch.
+type restoreFailedError struct {
+	err error
+}
+
+func (e *restoreFailedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *restoreFailedError) Unwrap() error {
+	return e.err
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *BranchRestoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&neontechv1alpha1.BranchRestore{}).
+		Complete(r)
+}
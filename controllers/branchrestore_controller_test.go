@@ -0,0 +1,251 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	neontechv1alpha1 "github.com/evanshortiss/neon-kube-operator/api/v1alpha1"
+	"github.com/evanshortiss/neon-kube-operator/neon"
+	"github.com/evanshortiss/neon-kube-operator/neon/neontest"
+)
+
+// newRestoreTest returns a reconciler for restore, whose branch "dev" is a
+// created branch of a new project on server.
+func newRestoreTest(t *testing.T, server *neontest.Server, restore *neontechv1alpha1.BranchRestore) (*BranchRestoreReconciler, neon.Branch) {
+	t.Helper()
+	projectId := server.AddProject("app")
+	branch, err := server.AddBranch(projectId, "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev := &neontechv1alpha1.Branch{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "default"},
+		Spec:       neontechv1alpha1.BranchSpec{ProjectId: projectId},
+		Status: neontechv1alpha1.BranchStatus{
+			State:     neontechv1alpha1.BranchStateCreated,
+			Id:        branch.Id,
+			ProjectId: projectId,
+			ParentId:  branch.ParentId,
+		},
+	}
+	scheme := newTestScheme()
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(dev, restore).Build()
+	return &BranchRestoreReconciler{
+		Client:      k8sClient,
+		Scheme:      scheme,
+		NeonClients: neon.NewClientPool(k8sClient, server.Client(), nil),
+	}, branch
+}
+
+func TestBranchRestore(t *testing.T) {
+	server := neontest.NewServer(t)
+	restore := &neontechv1alpha1.BranchRestore{
+		ObjectMeta: metav1.ObjectMeta{Name: "reset-dev", Namespace: "default"},
+		Spec: neontechv1alpha1.BranchRestoreSpec{
+			BranchRef:         "dev",
+			PreserveUnderName: "dev-backup",
+		},
+	}
+	r, dev := newRestoreTest(t, server, restore)
+	ctx := context.Background()
+
+	reconcileUntilDone(t, r, restore)
+	if err := r.Get(ctx, client.ObjectKeyFromObject(restore), restore); err != nil {
+		t.Fatal(err)
+	}
+	s := restore.Status
+	if s.State != neontechv1alpha1.BranchRestoreStateSucceeded || s.BranchId != dev.Id ||
+		s.SourceBranchId != dev.ParentId || len(s.Operations) != 2 || s.CompletedAt == "" {
+		t.Fatalf("unexpected status %+v", s)
+	}
+	if restored, _ := server.Branch(dev.ProjectId, dev.Id); restored.LastResetAt == "" {
+		t.Error("branch was not restored")
+	}
+	preserved := false
+	for _, b := range server.Branches(dev.ProjectId) {
+		preserved = preserved || b.Name == "dev-backup"
+	}
+	if !preserved {
+		t.Error("the previous state of the branch was not preserved")
+	}
+
+	// A finished restore is never run again.
+	reconcileUntilDone(t, r, restore)
+	server.ExpectRequests(t, "POST", "/projects/*/branches/*/restore", 1)
+}
+
+func TestBranchRestoreOperationFailed(t *testing.T) {
+	server := neontest.NewServer(t, neontest.WithOperationDuration(time.Hour))
+	timestamp := "2023-06-01T00:00:00Z"
+	restore := &neontechv1alpha1.BranchRestore{
+		ObjectMeta: metav1.ObjectMeta{Name: "rollback-dev", Namespace: "default"},
+		Spec: neontechv1alpha1.BranchRestoreSpec{
+			BranchRef:         "dev",
+			Source:            neontechv1alpha1.RestoreSource{Timestamp: &timestamp},
+			PreserveUnderName: "dev-before-rollback",
+		},
+	}
+	r, dev := newRestoreTest(t, server, restore)
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(restore)}
+
+	if result, err := r.Reconcile(ctx, req); err != nil || result.RequeueAfter == 0 {
+		t.Fatalf("Reconcile = %+v, %v, want a requeue while the restore runs", result, err)
+	}
+	if err := r.Get(ctx, req.NamespacedName, restore); err != nil {
+		t.Fatal(err)
+	}
+	if s := restore.Status; s.State != neontechv1alpha1.BranchRestoreStateRestoring || s.SourceBranchId != dev.Id {
+		t.Fatalf("unexpected status while restoring %+v", s)
+	}
+
+	server.SetOperationStatus(restore.Status.Operations[0], neon.OperationStatusFailed)
+	if result, err := r.Reconcile(ctx, req); err != nil || result.Requeue {
+		t.Fatalf("Reconcile = %+v, %v after the operation failed", result, err)
+	}
+	if err := r.Get(ctx, req.NamespacedName, restore); err != nil {
+		t.Fatal(err)
+	}
+	if s := restore.Status; s.State != neontechv1alpha1.BranchRestoreStateFailed || !strings.Contains(s.Message, "failed") {
+		t.Errorf("unexpected status after failure %+v", s)
+	}
+}
+
+func TestBranchRestoreIsNeverRepeated(t *testing.T) {
+	for _, applied := range []bool{false, true} {
+		server := neontest.NewServer(t)
+		restore := &neontechv1alpha1.BranchRestore{
+			ObjectMeta: metav1.ObjectMeta{Name: "reset-dev", Namespace: "default"},
+			Spec:       neontechv1alpha1.BranchRestoreSpec{BranchRef: "dev"},
+		}
+		r, dev := newRestoreTest(t, server, restore)
+		ctx := context.Background()
+
+		// The restore was recorded as started, but its result was lost.
+		if err := r.Get(ctx, client.ObjectKeyFromObject(restore), restore); err != nil {
+			t.Fatal(err)
+		}
+		restore.Status = neontechv1alpha1.BranchRestoreStatus{
+			State:          neontechv1alpha1.BranchRestoreStateRestoring,
+			BranchId:       dev.Id,
+			ProjectId:      dev.ProjectId,
+			SourceBranchId: dev.ParentId,
+			StartedAt:      time.Now().UTC().Format(time.RFC3339),
+		}
+		if err := r.Status().Update(ctx, restore); err != nil {
+			t.Fatal(err)
+		}
+		if applied {
+			if _, err := server.Client().RestoreBranch(ctx, restore); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Without the operations of the restore its outcome is unknown.
+		reconcileUntilDone(t, r, restore)
+		if err := r.Get(ctx, client.ObjectKeyFromObject(restore), restore); err != nil {
+			t.Fatal(err)
+		}
+		if s := restore.Status; s.State != neontechv1alpha1.BranchRestoreStateFailed || !strings.Contains(s.Message, "unknown") {
+			t.Errorf("applied %v: unexpected status %+v", applied, s)
+		}
+		if applied {
+			server.ExpectRequests(t, "POST", "/projects/*/branches/*/restore", 1)
+		} else {
+			server.ExpectRequests(t, "POST", "/projects/*/branches/*/restore", 0)
+		}
+	}
+}
+
+func TestBranchRestoreWithoutAPIKey(t *testing.T) {
+	server := neontest.NewServer(t)
+	restore := &neontechv1alpha1.BranchRestore{
+		ObjectMeta: metav1.ObjectMeta{Name: "reset-dev", Namespace: "default"},
+		Spec:       neontechv1alpha1.BranchRestoreSpec{BranchRef: "dev"},
+	}
+	r, _ := newRestoreTest(t, server, restore)
+	neonClient := server.Client()
+	neonClient.SetAPIKey("")
+	r.NeonClients = neon.NewClientPool(r.Client, neonClient, nil)
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(restore)}
+
+	// The request never reaches Neon, so the restore stays pending.
+	if _, err := r.Reconcile(ctx, req); !errors.Is(err, neon.ErrNoAPIKey) {
+		t.Fatalf("Reconcile error = %v, want %v", err, neon.ErrNoAPIKey)
+	}
+	if err := r.Get(ctx, req.NamespacedName, restore); err != nil {
+		t.Fatal(err)
+	}
+	if s := restore.Status; s.State != neontechv1alpha1.BranchRestoreStatePending || s.StartedAt != "" {
+		t.Fatalf("unexpected status without an API key %+v", s)
+	}
+
+	neonClient.SetAPIKey("neontest")
+	reconcileUntilDone(t, r, restore)
+	if err := r.Get(ctx, req.NamespacedName, restore); err != nil {
+		t.Fatal(err)
+	}
+	if s := restore.Status; s.State != neontechv1alpha1.BranchRestoreStateSucceeded {
+		t.Errorf("unexpected status once the key is set %+v", s)
+	}
+	server.ExpectRequests(t, "POST", "/projects/*/branches/*/restore", 1)
+}
+
+func TestBranchRestoreFromItselfRequiresPreserve(t *testing.T) {
+	server := neontest.NewServer(t)
+	timestamp := "2023-06-01T00:00:00Z"
+	restore := &neontechv1alpha1.BranchRestore{
+		ObjectMeta: metav1.ObjectMeta{Name: "rollback-dev", Namespace: "default"},
+		Spec: neontechv1alpha1.BranchRestoreSpec{
+			BranchRef: "dev",
+			Source:    neontechv1alpha1.RestoreSource{Timestamp: &timestamp},
+		},
+	}
+	r, dev := newRestoreTest(t, server, restore)
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(restore)}
+
+	if _, err := r.Reconcile(ctx, req); err == nil || !strings.Contains(err.Error(), "preserveUnderName") {
+		t.Fatalf("Reconcile error = %v, want it to ask for preserveUnderName", err)
+	}
+	if err := r.Get(ctx, req.NamespacedName, restore); err != nil {
+		t.Fatal(err)
+	}
+	if s := restore.Status; s.State != neontechv1alpha1.BranchRestoreStatePending || !strings.Contains(s.Message, "preserveUnderName") {
+		t.Errorf("unexpected status %+v", s)
+	}
+	server.ExpectRequests(t, "POST", "/projects/*/branches/*/restore", 0)
+
+	// Neon refuses the same request.
+	restore.Status.BranchId = dev.Id
+	restore.Status.ProjectId = dev.ProjectId
+	restore.Status.SourceBranchId = dev.Id
+	if _, err := server.Client().RestoreBranch(ctx, restore); err == nil {
+		t.Error("restoring a branch from itself without preserving it succeeded")
+	}
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	neontechv1alpha1 "github.com/evanshortiss/neon-kube-operator/api/v1alpha1"
+	"github.com/evanshortiss/neon-kube-operator/neon"
+)
+
+// setDegraded sets the Degraded condition from the error of a reconcile.
+// A refusal by the circuit breaker degrades a resource and a successful
+// reconcile clears it. Other errors, such as a missing API key or a
+// rejected request, say nothing about whether Neon is reachable, so they
+// leave the condition as it is and are reported in the status message.
+func setDegraded(conditions *[]metav1.Condition, generation int64, err error) {
+	switch {
+	case errors.Is(err, neon.ErrCircuitOpen):
+		meta.SetStatusCondition(conditions, metav1.Condition{
+			Type:               neontechv1alpha1.ConditionDegraded,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: generation,
+			Reason:             "NeonUnavailable",
+			Message:            "Calls to the Neon API are paused after repeated failures",
+		})
+	case err == nil:
+		meta.SetStatusCondition(conditions, metav1.Condition{
+			Type:               neontechv1alpha1.ConditionDegraded,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: generation,
+			Reason:             "NeonAvailable",
+			Message:            "The Neon API is reachable",
+		})
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	neontechv1alpha1 "github.com/evanshortiss/neon-kube-operator/api/v1alpha1"
+	"github.com/evanshortiss/neon-kube-operator/neon"
+)
+
+func TestSetDegraded(t *testing.T) {
+	var conditions []metav1.Condition
+	degraded := func() metav1.ConditionStatus {
+		c := meta.FindStatusCondition(conditions, neontechv1alpha1.ConditionDegraded)
+		if c == nil {
+			return ""
+		}
+		return c.Status
+	}
+
+	// Errors that say nothing about reachability set no condition.
+	setDegraded(&conditions, 1, neon.ErrNoAPIKey)
+	if got := degraded(); got != "" {
+		t.Fatalf("Degraded = %q after a missing API key, want unset", got)
+	}
+
+	setDegraded(&conditions, 1, fmt.Errorf("creating branch: %w", neon.ErrCircuitOpen))
+	if got := degraded(); got != metav1.ConditionTrue {
+		t.Fatalf("Degraded = %q after the breaker opened, want True", got)
+	}
+	for _, err := range []error{
+		neon.ErrNoAPIKey,
+		&neon.APIError{StatusCode: 400, Message: "bad request"},
+		context.Canceled,
+		fmt.Errorf("waiting for 1 operations to finish, %w", neon.ErrRetryAgain),
+	} {
+		setDegraded(&conditions, 2, err)
+		if got := degraded(); got != metav1.ConditionTrue {
+			t.Errorf("Degraded = %q after %v, want it left True", got, err)
+		}
+	}
+
+	setDegraded(&conditions, 2, nil)
+	if got := degraded(); got != metav1.ConditionFalse {
+		t.Fatalf("Degraded = %q after a successful reconcile, want False", got)
+	}
+	setDegraded(&conditions, 3, &neon.APIError{StatusCode: 404, Message: "not found"})
+	if got := degraded(); got != metav1.ConditionFalse {
+		t.Errorf("Degraded = %q after a rejected request, want it left False", got)
+	}
+}
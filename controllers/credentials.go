@@ -0,0 +1,197 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// APIKeySetter is implemented by neon.Client.
+type APIKeySetter interface {
+	SetAPIKey(apiKey string)
+}
+
+// APIKeySourceKind names where the operator's default API key is read from.
+type APIKeySourceKind string
+
+const (
+	APIKeyFromFile   APIKeySourceKind = "file"
+	APIKeyFromEnv    APIKeySourceKind = "env"
+	APIKeyFromSecret APIKeySourceKind = "secret"
+)
+
+// APIKeySources lists the places the operator's default API key can be
+// read from.
+type APIKeySources struct {
+	// File is the path of a file holding the key, such as a mounted Secret.
+	File string
+	// Env is the value of the NEON_API_KEY env var.
+	Env string
+	// Secret and Key select the key in a Secret.
+	Secret types.NamespacedName
+	Key    string
+}
+
+// Resolve returns the source the key is read from: File if it is set, even
+// if the file is empty or cannot be read, then Env if it is set, then the
+// Secret.
+func (s APIKeySources) Resolve() APIKeySourceKind {
+	switch {
+	case s.File != "":
+		return APIKeyFromFile
+	case s.Env != "":
+		return APIKeyFromEnv
+	default:
+		return APIKeyFromSecret
+	}
+}
+
+// Loader returns the runnable keeping the API key of client in sync with the
+// resolved source. A key from Env never changes, so it is set right away and
+// nil is returned.
+func (s APIKeySources) Loader(c cache.Cache, client APIKeySetter) manager.Runnable {
+	switch s.Resolve() {
+	case APIKeyFromFile:
+		return &APIKeyFileWatcher{Path: s.File, Interval: 10 * time.Second, Client: client}
+	case APIKeyFromEnv:
+		client.SetAPIKey(s.Env)
+		return nil
+	default:
+		return &CredentialsWatcher{Cache: c, Secret: s.Secret, Key: s.Key, Client: client}
+	}
+}
+
+// CredentialsWatcher keeps the API key of a Neon client in sync with a
+// Secret. The key is cleared while the Secret or its key is missing.
+//
+// It runs on every replica, not only the leader, so that each replica can
+// report through its readiness check whether it has a usable key.
+type CredentialsWatcher struct {
+	Cache  cache.Cache
+	Secret types.NamespacedName
+	Key    string
+	Client APIKeySetter
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (w *CredentialsWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+// Start watches the Secret until ctx is done.
+func (w *CredentialsWatcher) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("credentials").WithValues("secret", w.Secret.String())
+
+	informer, err := w.Cache.GetInformer(ctx, &v1.Secret{})
+	if err != nil {
+		return err
+	}
+	update := func(obj interface{}) {
+		secret, ok := obj.(*v1.Secret)
+		if !ok || secret.Namespace != w.Secret.Namespace || secret.Name != w.Secret.Name {
+			return
+		}
+		apiKey := string(secret.Data[w.Key])
+		if apiKey == "" {
+			logger.Info("Neon API key is missing from the secret", "key", w.Key)
+		} else {
+			logger.Info("Loaded Neon API key")
+		}
+		w.Client.SetAPIKey(apiKey)
+	}
+	if _, err := informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    update,
+		UpdateFunc: func(_, obj interface{}) { update(obj) },
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if secret, ok := obj.(*v1.Secret); ok && secret.Namespace == w.Secret.Namespace && secret.Name == w.Secret.Name {
+				logger.Info("Neon API key secret was deleted")
+				w.Client.SetAPIKey("")
+			}
+		},
+	}); err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+	return nil
+}
+
+// APIKeyFileWatcher keeps the API key of a Neon client in sync with a file,
+// such as a mounted Secret. The file is polled since mounted Secrets are
+// updated by swapping symlinks, and the key is cleared while it is missing.
+type APIKeyFileWatcher struct {
+	Path     string
+	Interval time.Duration
+	Client   APIKeySetter
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (w *APIKeyFileWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+// Start polls the file until ctx is done.
+func (w *APIKeyFileWatcher) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("credentials").WithValues("path", w.Path)
+
+	var last *string
+	load := func() {
+		data, err := os.ReadFile(w.Path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logger.Error(err, "failed to read the Neon API key file")
+			return
+		}
+		apiKey := strings.TrimSpace(string(data))
+		if last != nil && *last == apiKey {
+			return
+		}
+		if apiKey == "" {
+			logger.Info("Neon API key file is missing or empty")
+		} else {
+			logger.Info("Loaded Neon API key")
+		}
+		last = &apiKey
+		w.Client.SetAPIKey(apiKey)
+	}
+
+	load()
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			load()
+		}
+	}
+}
@@ -0,0 +1,269 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllertest"
+)
+
+// keyRecorder records the API keys it is given.
+type keyRecorder struct {
+	keys []string
+}
+
+func (r *keyRecorder) SetAPIKey(apiKey string) {
+	r.keys = append(r.keys, apiKey)
+}
+
+func TestAPIKeySources(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte("file-key\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	emptyFile := filepath.Join(dir, "empty")
+	if err := os.WriteFile(emptyFile, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	secret := types.NamespacedName{Namespace: "neon", Name: "neon-operator-secrets"}
+
+	for _, tc := range []struct {
+		name string
+		file string
+		env  string
+		want APIKeySourceKind
+		// key is the key set once the source has been loaded, if any.
+		key *string
+	}{
+		{name: "secret only", want: APIKeyFromSecret},
+		{name: "env only", env: "env-key", want: APIKeyFromEnv, key: ptr("env-key")},
+		{name: "file only", file: keyFile, want: APIKeyFromFile, key: ptr("file-key")},
+		{name: "file over env", file: keyFile, env: "env-key", want: APIKeyFromFile, key: ptr("file-key")},
+		// A configured file is never skipped, the key stays unset until it
+		// holds one.
+		{name: "empty file over env", file: emptyFile, env: "env-key", want: APIKeyFromFile, key: ptr("")},
+		{name: "missing file over env", file: filepath.Join(dir, "missing"), env: "env-key", want: APIKeyFromFile, key: ptr("")},
+		{name: "unreadable file over env", file: dir, env: "env-key", want: APIKeyFromFile},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sources := APIKeySources{File: tc.file, Env: tc.env, Secret: secret, Key: "neon-api-key"}
+			if got := sources.Resolve(); got != tc.want {
+				t.Fatalf("Resolve() = %q, want %q", got, tc.want)
+			}
+
+			client := &keyRecorder{}
+			loader := sources.Loader(nil, client)
+			switch l := loader.(type) {
+			case nil:
+				if tc.want != APIKeyFromEnv {
+					t.Fatal("expected a loader")
+				}
+			case *APIKeyFileWatcher:
+				if tc.want != APIKeyFromFile || l.Path != tc.file {
+					t.Fatalf("unexpected file watcher %+v", l)
+				}
+				// The file is read once before Start returns.
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				if err := l.Start(ctx); err != nil {
+					t.Fatal(err)
+				}
+			case *CredentialsWatcher:
+				if tc.want != APIKeyFromSecret || l.Secret != secret || l.Key != "neon-api-key" {
+					t.Fatalf("unexpected secret watcher %+v", l)
+				}
+			default:
+				t.Fatalf("unexpected loader %T", loader)
+			}
+
+			switch {
+			case tc.key == nil && len(client.keys) > 0:
+				t.Errorf("expected no key to be set, got %q", client.keys)
+			case tc.key != nil && (len(client.keys) != 1 || client.keys[0] != *tc.key):
+				t.Errorf("keys set = %q, want %q", client.keys, *tc.key)
+			}
+		})
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
+
+// keyChannel sends the API keys it is given, for watchers running in the
+// background.
+type keyChannel chan string
+
+func (c keyChannel) SetAPIKey(apiKey string) {
+	c <- apiKey
+}
+
+// expectKey waits for the next key set on keys.
+func expectKey(t *testing.T, keys keyChannel, want string) {
+	t.Helper()
+	select {
+	case got := <-keys:
+		if got != want {
+			t.Fatalf("key set = %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("key %q was not set", want)
+	}
+}
+
+func TestAPIKeyFileWatcher(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "key")
+	// write replaces the file the way a mounted Secret is updated, rather
+	// than writing to it in place.
+	write := func(key string) {
+		tmp := filepath.Join(dir, "tmp")
+		if err := os.WriteFile(tmp, []byte(key+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("key-a")
+
+	keys := make(keyChannel, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher := &APIKeyFileWatcher{Path: path, Interval: 10 * time.Millisecond, Client: keys}
+	go func() { _ = watcher.Start(ctx) }()
+	expectKey(t, keys, "key-a")
+
+	write("key-b")
+	expectKey(t, keys, "key-b")
+
+	// The key is cleared while the file is missing.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	expectKey(t, keys, "")
+
+	write("key-c")
+	expectKey(t, keys, "key-c")
+
+	// An unchanged file sets nothing.
+	time.Sleep(50 * time.Millisecond)
+	if len(keys) > 0 {
+		t.Errorf("unexpected key %q", <-keys)
+	}
+}
+
+// registeringCache hands out a fake Secret informer and reports when a
+// handler has been added to it.
+type registeringCache struct {
+	informertest.FakeInformers
+	informer   *controllertest.FakeInformer
+	handler    toolscache.ResourceEventHandler
+	registered chan struct{}
+}
+
+func (c *registeringCache) GetInformer(ctx context.Context, obj client.Object) (cache.Informer, error) {
+	return &registeringInformer{FakeInformer: c.informer, cache: c}, nil
+}
+
+type registeringInformer struct {
+	*controllertest.FakeInformer
+	cache *registeringCache
+}
+
+func (i *registeringInformer) AddEventHandler(handler toolscache.ResourceEventHandler) (toolscache.ResourceEventHandlerRegistration, error) {
+	i.cache.handler = handler
+	defer close(i.cache.registered)
+	return i.FakeInformer.AddEventHandler(handler)
+}
+
+func TestCredentialsWatcher(t *testing.T) {
+	informers := &registeringCache{
+		informer:   &controllertest.FakeInformer{},
+		registered: make(chan struct{}),
+	}
+	keys := make(keyChannel, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher := &CredentialsWatcher{
+		Cache:  informers,
+		Secret: types.NamespacedName{Namespace: "neon", Name: "neon-operator-secrets"},
+		Key:    "neon-api-key",
+		Client: keys,
+	}
+	go func() { _ = watcher.Start(ctx) }()
+	select {
+	case <-informers.registered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the watcher did not add an event handler")
+	}
+
+	secret := func(namespace, name, key string) *v1.Secret {
+		return &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Data:       map[string][]byte{"neon-api-key": []byte(key)},
+		}
+	}
+	informer := informers.informer
+	first := secret("neon", "neon-operator-secrets", "key-a")
+	informer.Add(first)
+	expectKey(t, keys, "key-a")
+
+	// Other Secrets are ignored.
+	informer.Add(secret("neon", "other", "key-x"))
+	informer.Add(secret("default", "neon-operator-secrets", "key-x"))
+
+	rotated := secret("neon", "neon-operator-secrets", "key-b")
+	informer.Update(first, rotated)
+	expectKey(t, keys, "key-b")
+
+	// A Secret without the key clears it.
+	emptied := secret("neon", "neon-operator-secrets", "")
+	emptied.Data = nil
+	informer.Update(rotated, emptied)
+	expectKey(t, keys, "")
+
+	informer.Update(emptied, rotated)
+	expectKey(t, keys, "key-b")
+
+	informer.Delete(rotated)
+	expectKey(t, keys, "")
+
+	informer.Add(first)
+	expectKey(t, keys, "key-a")
+
+	// A delete missed by the watch arrives as a tombstone.
+	informers.handler.OnDelete(toolscache.DeletedFinalStateUnknown{Key: "neon/neon-operator-secrets", Obj: first})
+	expectKey(t, keys, "")
+
+	if len(keys) > 0 {
+		t.Errorf("unexpected key %q", <-keys)
+	}
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neontechv1alpha1 "github.com/evanshortiss/neon-kube-operator/api/v1alpha1"
+	"github.com/evanshortiss/neon-kube-operator/neon"
+)
+
+// DatabaseReconciler reconciles a Database object
+type DatabaseReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// NeonClients provides the Neon client for the credentials a database
+	// references.
+	NeonClients neon.ClientProvider
+
+	// Recorder records Neon API calls made for a database as Events on it.
+	Recorder record.EventRecorder
+	// AuditLog, if set, also receives the calls as JSON lines.
+	AuditLog *AuditLog
+}
+
+//+kubebuilder:rbac:groups=neon.tech,resources=databases,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=neon.tech,resources=databases/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=neon.tech,resources=databases/finalizers,verbs=update
+
+// Reconcile creates, renames and deletes the Postgres database of a
+// Database resource on its branch.
+func (r *DatabaseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	ctx, span := startReconcileSpan(ctx, "Database", req)
+	result, err := r.reconcileRequest(ctx, req)
+	endReconcileSpan(span, result, err)
+	return result, err
+}
+
+// reconcileRequest does the work of Reconcile within its trace span.
+func (r *DatabaseReconciler) reconcileRequest(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	d := &neontechv1alpha1.Database{}
+	err := r.Client.Get(ctx, req.NamespacedName, d)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			logger.Info("database resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	trace.SpanFromContext(ctx).SetAttributes(
+		neon.ProjectIdKey.String(d.Status.ProjectId),
+		neon.BranchIdKey.String(d.Status.BranchId),
+	)
+	ctx = withAuditor(ctx, r.Recorder, r.AuditLog, "Database", d)
+	if err = AddFinalizer(ctx, r.Client, d); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if d.DeletionTimestamp != nil {
+		_ = r.updateState(ctx, d, neontechv1alpha1.DatabaseStateDeleting)
+		if err := r.ExecuteFinalizer(ctx, d); err != nil {
+			if errors.Is(err, neon.ErrRetryAgain) {
+				return ctrl.Result{Requeue: true, RequeueAfter: 5 * time.Second}, nil
+			}
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	err = r.reconcile(ctx, d)
+	if err != nil {
+		d.Status.Message = neon.ErrorMessage(err)
+	} else {
+		d.Status.Reset()
+	}
+	setDegraded(&d.Status.Conditions, d.Generation, err)
+
+	if updateErr := r.Status().Update(ctx, d); updateErr != nil {
+		return ctrl.Result{}, updateErr
+	}
+
+	if errors.Is(err, neon.ErrRetryAgain) {
+		return ctrl.Result{Requeue: true, RequeueAfter: 5 * time.Second}, nil
+	}
+	return ctrl.Result{}, err
+}
+
+// ExecuteFinalizer deletes the database in Neon unless it was adopted and is
+// not managed, then removes the finalizer.
+func (r *DatabaseReconciler) ExecuteFinalizer(ctx context.Context, database *neontechv1alpha1.Database) error {
+	logger := log.FromContext(ctx)
+	if !database.Managed() {
+		logger.Info("Leaving adopted database in Neon", "name", database.Name, "database", database.Status.Name)
+	} else if database.Status.Name != "" && database.Status.BranchId != "" {
+		logger.Info("Reconciling deletion of database", "name", database.Name)
+		neonClient, err := r.NeonClients.ClientFor(ctx, database.Namespace, database.Spec.CredentialsRef)
+		if err != nil {
+			return err
+		}
+		if _, err := neonClient.DeleteDatabase(ctx, database); err != nil {
+			return err
+		}
+	}
+	if ok := controllerutil.RemoveFinalizer(database, neonFinalizer); ok {
+		if err := r.Update(ctx, database); err != nil {
+			return err
+		}
+		logger.Info("Finalizer removed from database", "name", database.Name)
+	}
+	return nil
+}
+
+func (r *DatabaseReconciler) reconcile(ctx context.Context, database *neontechv1alpha1.Database) error {
+	neonClient, err := r.NeonClients.ClientFor(ctx, database.Namespace, database.Spec.CredentialsRef)
+	if err != nil {
+		return err
+	}
+	err = resolveBranchOnce(ctx, r.Client, database.Namespace, database.Spec.BranchFrom, &database.Status.BranchId, &database.Status.ProjectId)
+	if err != nil {
+		return err
+	}
+
+	operations := database.Status.PendingOperations
+	resp, err := neonClient.GetDatabase(ctx, database)
+	if err != nil {
+		if !errors.Is(err, neon.ErrDatabaseNotFound) {
+			return err
+		}
+		var adopted bool
+		resp, adopted, err = r.adoptOrCreate(ctx, neonClient, database)
+		if err != nil {
+			return err
+		}
+		operations = neon.OperationIds(resp.Operations)
+		database.Status.Adopted = adopted
+	}
+	r.setStatus(database, resp.Database)
+
+	changed := resp.Database.Name != database.NeonName() || resp.Database.OwnerName != database.Spec.OwnerName
+	if changed && database.Managed() {
+		log.FromContext(ctx).Info("Updating database", "name", database.Name, "from", resp.Database.Name, "to", database.NeonName())
+		resp, err = neonClient.UpdateDatabase(ctx, database)
+		if err != nil {
+			return err
+		}
+		r.setStatus(database, resp.Database)
+		operations = append(operations, neon.OperationIds(resp.Operations)...)
+	}
+
+	pending, err := neonClient.PendingOperations(ctx, database.Status.ProjectId, operations)
+	database.Status.PendingOperations = pending
+	if err != nil || len(pending) > 0 {
+		database.Status.State = neontechv1alpha1.DatabaseStateCreating
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("waiting for %d operations to finish, %w", len(pending), neon.ErrRetryAgain)
+	}
+	database.Status.State = neontechv1alpha1.DatabaseStateCreated
+	return nil
+}
+
+// setStatus replaces the status with the observed database, keeping the
+// fields that Neon does not return.
+func (r *DatabaseReconciler) setStatus(database *neontechv1alpha1.Database, observed neon.Database) {
+	previous := database.Status
+	database.Status = neon.NewDatabaseStatus(observed)
+	database.Status.State = previous.State
+	database.Status.ProjectId = previous.ProjectId
+	database.Status.Adopted = previous.Adopted
+	database.Status.PendingOperations = previous.PendingOperations
+	database.Status.Conditions = previous.Conditions
+}
+
+// adoptOrCreate creates the database on its branch. A database that has
+// never been created by this resource first applies the adoption policy to
+// an existing database with the same name. A database recorded by another
+// Database resource is never adopted. It reports whether the database was
+// adopted.
+func (r *DatabaseReconciler) adoptOrCreate(ctx context.Context, neonClient neon.DatabaseClient, database *neontechv1alpha1.Database) (*neon.DatabaseResponse, bool, error) {
+	logger := log.FromContext(ctx)
+	if database.Status.Name == "" && database.Spec.AdoptionPolicy != neontechv1alpha1.AdoptionPolicyCreate {
+		existing, err := neonClient.FindDatabase(ctx, database)
+		if err == nil {
+			owned, err := ownedDatabaseNames(ctx, r.Client, database)
+			if err != nil {
+				return nil, false, err
+			}
+			if owned[existing.BranchId+"/"+existing.Name] {
+				return nil, false, fmt.Errorf("database %s on branch %s belongs to another Database resource", existing.Name, existing.BranchId)
+			}
+		}
+		switch {
+		case err == nil && database.Spec.AdoptionPolicy == neontechv1alpha1.AdoptionPolicyFail:
+			return nil, false, fmt.Errorf("database %s already exists on branch %s", existing.Name, existing.BranchId)
+		case err == nil:
+			logger.Info("Adopting existing database", "name", database.Name, "database", existing.Name)
+			return &neon.DatabaseResponse{Database: *existing}, true, nil
+		case !errors.Is(err, neon.ErrDatabaseNotFound):
+			return nil, false, err
+		}
+	}
+	logger.Info("Creating database", "name", database.Name)
+	resp, err := neonClient.CreateDatabase(ctx, database)
+	return resp, false, err
+}
+
+func (r *DatabaseReconciler) updateState(ctx context.Context, database *neontechv1alpha1.Database, state neontechv1alpha1.DatabaseState) error {
+	database.Status.State = state
+	return r.Client.Status().Update(ctx, database)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DatabaseReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&neontechv1alpha1.Database{}).
+		Complete(r)
+}
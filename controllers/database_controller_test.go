@@ -0,0 +1,245 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	neontechv1alpha1 "github.com/evanshortiss/neon-kube-operator/api/v1alpha1"
+	"github.com/evanshortiss/neon-kube-operator/neon"
+	"github.com/evanshortiss/neon-kube-operator/neon/neontest"
+)
+
+func TestDatabaseLifecycle(t *testing.T) {
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("shop")
+	var branchId string
+	for _, b := range server.Branches(projectId) {
+		if b.Primary {
+			branchId = b.Id
+		}
+	}
+	databaseNames := func() []string {
+		var names []string
+		for _, d := range server.Databases(projectId, branchId) {
+			names = append(names, d.Name)
+		}
+		return names
+	}
+
+	database := &neontechv1alpha1.Database{
+		ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "default"},
+		Spec: neontechv1alpha1.DatabaseSpec{
+			BranchFrom: neontechv1alpha1.BranchFrom{ProjectId: projectId, BranchId: branchId},
+			OwnerName:  "shop_owner",
+		},
+	}
+	scheme := newTestScheme()
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(database).Build()
+	r := &DatabaseReconciler{
+		Client:      k8sClient,
+		Scheme:      scheme,
+		NeonClients: neon.NewClientPool(k8sClient, server.Client(), nil),
+	}
+	ctx := context.Background()
+	get := func() {
+		t.Helper()
+		if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(database), database); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reconcileUntilDone(t, r, database)
+	get()
+	if s := database.Status; s.State != neontechv1alpha1.DatabaseStateCreated || s.Name != "orders" ||
+		s.BranchId != branchId || s.ProjectId != projectId || s.Id == 0 {
+		t.Fatalf("unexpected status after create %+v", s)
+	}
+
+	database.Spec.Name = "orders_v2"
+	if err := k8sClient.Update(ctx, database); err != nil {
+		t.Fatal(err)
+	}
+	reconcileUntilDone(t, r, database)
+	get()
+	if database.Status.Name != "orders_v2" {
+		t.Errorf("status name = %q after rename", database.Status.Name)
+	}
+	if got := databaseNames(); len(got) != 2 || got[0] != "neondb" || got[1] != "orders_v2" {
+		t.Errorf("databases after rename = %v", got)
+	}
+
+	if err := k8sClient.Delete(ctx, database); err != nil {
+		t.Fatal(err)
+	}
+	reconcileUntilDone(t, r, database)
+	if got := databaseNames(); len(got) != 1 || got[0] != "neondb" {
+		t.Errorf("databases after delete = %v", got)
+	}
+}
+
+func TestAdoptedDatabaseIsNotDeleted(t *testing.T) {
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("shop")
+	var branchId string
+	for _, b := range server.Branches(projectId) {
+		if b.Primary {
+			branchId = b.Id
+		}
+	}
+	database := &neontechv1alpha1.Database{
+		ObjectMeta: metav1.ObjectMeta{Name: "neondb", Namespace: "default"},
+		Spec: neontechv1alpha1.DatabaseSpec{
+			BranchFrom:     neontechv1alpha1.BranchFrom{ProjectId: projectId, BranchId: branchId},
+			OwnerName:      "shop_owner",
+			AdoptionPolicy: neontechv1alpha1.AdoptionPolicyAdopt,
+		},
+	}
+	scheme := newTestScheme()
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(database).Build()
+	r := &DatabaseReconciler{
+		Client:      k8sClient,
+		Scheme:      scheme,
+		NeonClients: neon.NewClientPool(k8sClient, server.Client(), nil),
+	}
+	ctx := context.Background()
+
+	reconcileUntilDone(t, r, database)
+	if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(database), database); err != nil {
+		t.Fatal(err)
+	}
+	if !database.Status.Adopted || database.Status.State != neontechv1alpha1.DatabaseStateCreated {
+		t.Fatalf("database was not adopted: %+v", database.Status)
+	}
+
+	if err := k8sClient.Delete(ctx, database); err != nil {
+		t.Fatal(err)
+	}
+	reconcileUntilDone(t, r, database)
+	if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(database), database); err == nil {
+		t.Error("finalizer was not removed")
+	}
+	server.ExpectRequests(t, "DELETE", "/projects/*/branches/*/databases/*", 0)
+	if got := server.Databases(projectId, branchId); len(got) != 1 || got[0].Name != "neondb" {
+		t.Errorf("databases after delete = %v", got)
+	}
+}
+
+func TestAdoptedDatabaseIsLeftAlone(t *testing.T) {
+	for _, manage := range []bool{false, true} {
+		server := neontest.NewServer(t)
+		projectId := server.AddProject("shop")
+		var branchId string
+		for _, b := range server.Branches(projectId) {
+			if b.Primary {
+				branchId = b.Id
+			}
+		}
+		database := &neontechv1alpha1.Database{
+			ObjectMeta: metav1.ObjectMeta{Name: "neondb", Namespace: "default"},
+			Spec: neontechv1alpha1.DatabaseSpec{
+				BranchFrom:    neontechv1alpha1.BranchFrom{ProjectId: projectId, BranchId: branchId},
+				OwnerName:     "shop_owner",
+				ManageAdopted: manage,
+			},
+		}
+		scheme := newTestScheme()
+		k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(database).Build()
+		r := &DatabaseReconciler{
+			Client:      k8sClient,
+			Scheme:      scheme,
+			NeonClients: neon.NewClientPool(k8sClient, server.Client(), nil),
+		}
+		ctx := context.Background()
+
+		reconcileUntilDone(t, r, database)
+		if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(database), database); err != nil {
+			t.Fatal(err)
+		}
+		if !database.Status.Adopted {
+			t.Fatalf("database was not adopted: %+v", database.Status)
+		}
+
+		// A different owner in the spec does not change an unmanaged
+		// database.
+		database.Spec.OwnerName = "someone_else"
+		if err := k8sClient.Update(ctx, database); err != nil {
+			t.Fatal(err)
+		}
+		if !manage {
+			reconcileUntilDone(t, r, database)
+			server.ExpectRequests(t, "PATCH", "/projects/*/branches/*/databases/*", 0)
+		}
+
+		if err := k8sClient.Delete(ctx, database); err != nil {
+			t.Fatal(err)
+		}
+		reconcileUntilDone(t, r, database)
+		if manage {
+			server.ExpectRequests(t, "DELETE", "/projects/*/branches/*/databases/*", 1)
+		} else {
+			server.ExpectRequests(t, "DELETE", "/projects/*/branches/*/databases/*", 0)
+		}
+	}
+}
+
+func TestDatabaseDoesNotAdoptOwnedDatabase(t *testing.T) {
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("shop")
+	var branchId string
+	for _, b := range server.Branches(projectId) {
+		if b.Primary {
+			branchId = b.Id
+		}
+	}
+	newDatabase := func(namespace string) *neontechv1alpha1.Database {
+		return &neontechv1alpha1.Database{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: namespace},
+			Spec: neontechv1alpha1.DatabaseSpec{
+				BranchFrom: neontechv1alpha1.BranchFrom{ProjectId: projectId, BranchId: branchId},
+				OwnerName:  "shop_owner",
+			},
+		}
+	}
+	first, second := newDatabase("team-a"), newDatabase("team-b")
+	scheme := newTestScheme()
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(first, second).Build()
+	r := &DatabaseReconciler{
+		Client:      k8sClient,
+		Scheme:      scheme,
+		NeonClients: neon.NewClientPool(k8sClient, server.Client(), nil),
+	}
+	ctx := context.Background()
+
+	reconcileUntilDone(t, r, first)
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(second)}); err == nil {
+		t.Fatal("expected the second resource to fail to adopt the database")
+	}
+	if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(second), second); err != nil {
+		t.Fatal(err)
+	}
+	if second.Status.Adopted || !strings.Contains(second.Status.Message, "another Database resource") {
+		t.Errorf("unexpected status of the second resource %+v", second.Status)
+	}
+}
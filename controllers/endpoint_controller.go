@@ -22,10 +22,12 @@ import (
 	"fmt"
 	"time"
 
+	"go.opentelemetry.io/otel/trace"
 	v1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -41,14 +43,22 @@ type EndpointReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
 
-	NeonClient *neon.Client
+	// NeonClients provides the Neon client for the credentials an endpoint
+	// references.
+	NeonClients neon.ClientProvider
+
+	// Recorder records Neon API calls made for an endpoint as Events on it.
+	Recorder record.EventRecorder
+	// AuditLog, if set, also receives the calls as JSON lines.
+	AuditLog *AuditLog
 }
 
 const (
 	secretNameTemplate          = "neon-%s-host"
 	secretHostField             = "host"
-	hostTemplateWithCredentials = "postgresql://%s:%s@%s/neondb?sslmode=require"
+	hostTemplateWithCredentials = "postgresql://%s:%s@%s/%s?sslmode=require"
 	hostTemplate                = "%s"
+	defaultDatabaseName         = "neondb"
 )
 
 //+kubebuilder:rbac:groups=neon.tech,resources=endpoints,verbs=get;list;watch;create;update;patch;delete
@@ -65,6 +75,14 @@ const (
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.14.1/pkg/reconcile
 func (r *EndpointReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	ctx, span := startReconcileSpan(ctx, "Endpoint", req)
+	result, err := r.reconcileRequest(ctx, req)
+	endReconcileSpan(span, result, err)
+	return result, err
+}
+
+// reconcileRequest does the work of Reconcile within its trace span.
+func (r *EndpointReconciler) reconcileRequest(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	var err error
 
 	logger := log.FromContext(ctx)
@@ -77,14 +95,29 @@ func (r *EndpointReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		}
 		return ctrl.Result{}, err
 	}
+	trace.SpanFromContext(ctx).SetAttributes(
+		neon.ProjectIdKey.String(e.Status.ProjectId),
+		neon.BranchIdKey.String(e.Status.BranchId),
+		neon.EndpointIdKey.String(e.Status.Id),
+	)
+
+	ctx = withAuditor(ctx, r.Recorder, r.AuditLog, "Endpoint", e)
 
 	if err = AddFinalizer(ctx, r.Client, e); err != nil {
 		return ctrl.Result{}, err
 	}
 
 	if e.DeletionTimestamp != nil {
+		if e.Status.State != neontechv1alpha1.EndpointStateDeleting {
+			// Operations started by the creation no longer need to be
+			// waited on, the deletion tracks its own operations.
+			e.Status.PendingOperations = nil
+		}
 		_ = r.updateState(ctx, e, neontechv1alpha1.EndpointStateDeleting)
 		if err = r.ExecuteFinalizer(ctx, e); err != nil {
+			if errors.Is(err, neon.ErrRetryAgain) {
+				return ctrl.Result{Requeue: true, RequeueAfter: 5 * time.Second}, nil
+			}
 			return ctrl.Result{}, err
 		}
 		return ctrl.Result{}, nil
@@ -92,10 +125,11 @@ func (r *EndpointReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 
 	err = r.reconcile(ctx, e)
 	if err != nil {
-		e.Status.Message = err.Error()
+		e.Status.Message = neon.ErrorMessage(err)
 	} else {
 		e.Status.Reset()
 	}
+	setDegraded(&e.Status.Conditions, e.Generation, err)
 
 	tries := 0
 	for tries < 5 {
@@ -107,6 +141,7 @@ func (r *EndpointReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		if tries == 4 {
 			return ctrl.Result{}, updateErr
 		}
+		tries++
 	}
 
 	if errors.Is(err, neon.ErrRetryAgain) {
@@ -115,11 +150,16 @@ func (r *EndpointReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	return ctrl.Result{}, err
 }
 
+// ExecuteFinalizer deletes the endpoint in Neon unless it was adopted or
+// never created, then removes the finalizer.
 func (r *EndpointReconciler) ExecuteFinalizer(ctx context.Context, endpoint *neontechv1alpha1.Endpoint) error {
 	logger := log.FromContext(ctx)
-	logger.Info("Reconciling deletion of endpoint", "name", endpoint.Name)
-	if _, err := r.NeonClient.DeleteEndpoint(ctx, r.Client, endpoint); err != nil {
-		return err
+	if endpoint.Status.Adopted {
+		logger.Info("Leaving adopted endpoint in Neon", "name", endpoint.Name, "id", endpoint.Status.Id)
+	} else if endpoint.Status.Id != "" {
+		if err := r.deleteEndpoint(ctx, endpoint); err != nil {
+			return err
+		}
 	}
 	if ok := controllerutil.RemoveFinalizer(endpoint, neonFinalizer); ok {
 		if err := r.Update(ctx, endpoint); err != nil {
@@ -130,9 +170,44 @@ func (r *EndpointReconciler) ExecuteFinalizer(ctx context.Context, endpoint *neo
 	return nil
 }
 
+// deleteEndpoint deletes the endpoint in Neon and waits for the operations
+// of the deletion to finish.
+func (r *EndpointReconciler) deleteEndpoint(ctx context.Context, endpoint *neontechv1alpha1.Endpoint) error {
+	log.FromContext(ctx).Info("Reconciling deletion of endpoint", "name", endpoint.Name)
+	neonClient, err := r.NeonClients.ClientFor(ctx, endpoint.Namespace, endpoint.Spec.CredentialsRef)
+	if err != nil {
+		return err
+	}
+	operations := endpoint.Status.PendingOperations
+	if len(operations) == 0 {
+		resp, err := neonClient.DeleteEndpoint(ctx, r.Client, endpoint)
+		if err != nil {
+			return err
+		}
+		if resp != nil {
+			operations = neon.OperationIds(resp.Operations)
+		}
+	}
+	pending, err := neonClient.PendingOperations(ctx, endpoint.Status.ProjectId, operations)
+	if len(pending) > 0 || err != nil {
+		endpoint.Status.PendingOperations = pending
+		if updateErr := r.Status().Update(ctx, endpoint); updateErr != nil {
+			return updateErr
+		}
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("waiting for %d operations to finish, %w", len(pending), neon.ErrRetryAgain)
+	}
+	return nil
+}
+
 func (r *EndpointReconciler) reconcile(ctx context.Context, endpoint *neontechv1alpha1.Endpoint) error {
-	logger := log.FromContext(ctx)
-	resp, err := r.NeonClient.GetEndpoint(ctx, r.Client, endpoint)
+	neonClient, err := r.NeonClients.ClientFor(ctx, endpoint.Namespace, endpoint.Spec.CredentialsRef)
+	if err != nil {
+		return err
+	}
+	resp, err := neonClient.GetEndpoint(ctx, r.Client, endpoint)
 	shouldCreate := false
 	if err != nil {
 		if !errors.Is(err, neon.ErrEndpointNotFound) {
@@ -141,25 +216,69 @@ func (r *EndpointReconciler) reconcile(ctx context.Context, endpoint *neontechv1
 		shouldCreate = true
 	}
 
+	operations := endpoint.Status.PendingOperations
+	adopted := endpoint.Status.Adopted
 	if shouldCreate {
-		logger.Info("Creating endpoint", "name", endpoint.Name)
-		resp, err = r.NeonClient.CreateEndpoint(ctx, r.Client, endpoint)
+		resp, adopted, err = r.adoptOrCreate(ctx, neonClient, endpoint)
 		if err != nil {
 			return err
 		}
+		operations = neon.OperationIds(resp.Operations)
 	}
 
-	endpoint.Status = neon.NewEndpointStatus(resp)
+	conditions := endpoint.Status.Conditions
+	endpoint.Status = neon.NewEndpointStatus(resp.Endpoint)
+	endpoint.Status.Conditions = conditions
+	endpoint.Status.Adopted = adopted
+
+	// The connection Secret is only written once the compute is able to
+	// accept connections.
+	pending, err := neonClient.PendingOperations(ctx, endpoint.Status.ProjectId, operations)
+	endpoint.Status.PendingOperations = pending
+	if err != nil || len(pending) > 0 {
+		endpoint.Status.State = neontechv1alpha1.EndpointStateCreating
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("waiting for %d operations to finish, %w", len(pending), neon.ErrRetryAgain)
+	}
 	endpoint.Status.State = neontechv1alpha1.EndpointStateCreated
 
-	err = r.reconcileSecret(ctx, endpoint)
+	err = r.reconcileSecret(ctx, neonClient, endpoint)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *EndpointReconciler) reconcileSecret(ctx context.Context, e *neontechv1alpha1.Endpoint) error {
+// adoptOrCreate creates the endpoint in Neon. An endpoint that has never been
+// created by this resource first applies the adoption policy to an existing
+// endpoint of the same type on the branch. It reports whether the endpoint
+// was adopted.
+func (r *EndpointReconciler) adoptOrCreate(ctx context.Context, neonClient neon.EndpointClient, endpoint *neontechv1alpha1.Endpoint) (*neon.EndpointResponse, bool, error) {
+	logger := log.FromContext(ctx)
+	if endpoint.Status.Id == "" && endpoint.Spec.AdoptionPolicy != neontechv1alpha1.AdoptionPolicyCreate {
+		owned, err := ownedEndpointIds(ctx, r.Client, endpoint)
+		if err != nil {
+			return nil, false, err
+		}
+		existing, err := neonClient.FindEndpoint(ctx, r.Client, endpoint, owned)
+		switch {
+		case err == nil && endpoint.Spec.AdoptionPolicy == neontechv1alpha1.AdoptionPolicyFail:
+			return nil, false, fmt.Errorf("%s endpoint %s already exists on branch %s", existing.Type, existing.Id, existing.BranchId)
+		case err == nil:
+			logger.Info("Adopting existing endpoint", "name", endpoint.Name, "id", existing.Id)
+			return &neon.EndpointResponse{Endpoint: *existing}, true, nil
+		case !errors.Is(err, neon.ErrEndpointNotFound):
+			return nil, false, err
+		}
+	}
+	logger.Info("Creating endpoint", "name", endpoint.Name)
+	resp, err := neonClient.CreateEndpoint(ctx, r.Client, endpoint)
+	return resp, false, err
+}
+
+func (r *EndpointReconciler) reconcileSecret(ctx context.Context, neonClient neon.EndpointClient, e *neontechv1alpha1.Endpoint) error {
 	logger := log.FromContext(ctx)
 
 	cm := &v1.Secret{
@@ -181,15 +300,22 @@ func (r *EndpointReconciler) reconcileSecret(ctx context.Context, e *neontechv1a
 		if err != nil {
 			return err
 		}
-		role, err := r.NeonClient.GetFirstRole(ctx, projectId, branchId)
-		if err != nil {
-			return err
+		role := e.Spec.RoleName
+		if role == "" {
+			role, err = neonClient.GetFirstRole(ctx, projectId, branchId)
+			if err != nil {
+				return err
+			}
 		}
-		pass, err := r.NeonClient.GetRolePassword(ctx, projectId, branchId, role)
+		pass, err := neonClient.GetRolePassword(ctx, projectId, branchId, role)
 		if err != nil {
 			return err
 		}
-		hostString = fmt.Sprintf(hostTemplateWithCredentials, role, pass, e.Status.Host)
+		database := e.Spec.DatabaseName
+		if database == "" {
+			database = defaultDatabaseName
+		}
+		hostString = fmt.Sprintf(hostTemplateWithCredentials, role, pass, e.Status.Host, database)
 	} else {
 		hostString = fmt.Sprintf(hostTemplate, e.Status.Host)
 	}
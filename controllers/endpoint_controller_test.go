@@ -0,0 +1,213 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	neontechv1alpha1 "github.com/evanshortiss/neon-kube-operator/api/v1alpha1"
+	"github.com/evanshortiss/neon-kube-operator/neon"
+	"github.com/evanshortiss/neon-kube-operator/neon/neontest"
+	"github.com/evanshortiss/neon-kube-operator/neon/redact"
+)
+
+// rejectingSecretsClient fails to create Secrets with an error quoting
+// their data, as an admission webhook might.
+type rejectingSecretsClient struct {
+	client.Client
+}
+
+func (c rejectingSecretsClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if secret, ok := obj.(*v1.Secret); ok {
+		return fmt.Errorf("admission webhook denied secret with host %s", secret.Data[secretHostField])
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func TestEndpointCredentialsAreRedacted(t *testing.T) {
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("test")
+	var primary neon.Branch
+	for _, b := range server.Branches(projectId) {
+		if b.Primary {
+			primary = b
+		}
+	}
+	roles := server.Roles(projectId, primary.Id)
+	if len(roles) == 0 {
+		t.Fatal("expected the primary branch to have a role")
+	}
+	password, err := server.Client().GetRolePassword(context.Background(), projectId, primary.Id, roles[0].Name)
+	if err != nil || password == "" {
+		t.Fatalf("GetRolePassword: %q, %v", password, err)
+	}
+
+	scheme := newTestScheme()
+	endpoint := &neontechv1alpha1.Endpoint{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+		Spec: neontechv1alpha1.EndpointSpec{
+			BranchFrom:         neontechv1alpha1.BranchFrom{ProjectId: projectId, BranchId: primary.Id},
+			Type:               string(neontechv1alpha1.EndpointTypeReadWrite),
+			IncludeCredentials: true,
+		},
+	}
+	k8sClient := rejectingSecretsClient{fake.NewClientBuilder().WithScheme(scheme).WithObjects(endpoint).Build()}
+	r := &EndpointReconciler{
+		Client:      k8sClient,
+		Scheme:      scheme,
+		NeonClients: neon.NewClientPool(k8sClient, server.Client(), nil),
+	}
+
+	var logs bytes.Buffer
+	logger := zap.New(zap.WriteTo(redact.NewWriter(&logs)))
+	ctx := log.IntoContext(context.Background(), logger)
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(endpoint)}
+	_, err = r.Reconcile(ctx, req)
+	if err == nil {
+		t.Fatal("expected the reconcile to fail")
+	}
+	logger.Error(err, "Reconciler error")
+
+	got := &neontechv1alpha1.Endpoint{}
+	if err := k8sClient.Get(ctx, req.NamespacedName, got); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), password) {
+		t.Errorf("password found in endpoint %s", data)
+	}
+	if !strings.Contains(got.Status.Message, redact.Mask) {
+		t.Errorf("expected a redacted status message, got %q", got.Status.Message)
+	}
+	if strings.Contains(logs.String(), password) {
+		t.Errorf("password found in logs %s", logs.String())
+	}
+	if !strings.Contains(logs.String(), redact.Mask) {
+		t.Errorf("expected the error to be logged, got %s", logs.String())
+	}
+}
+
+func TestEndpointDoesNotAdoptOwnedEndpoint(t *testing.T) {
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("test")
+	var primary neon.Branch
+	for _, b := range server.Branches(projectId) {
+		if b.Primary {
+			primary = b
+		}
+	}
+	newEndpoint := func(name string) *neontechv1alpha1.Endpoint {
+		return &neontechv1alpha1.Endpoint{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: neontechv1alpha1.EndpointSpec{
+				BranchFrom: neontechv1alpha1.BranchFrom{ProjectId: projectId, BranchId: primary.Id},
+				Type:       string(neontechv1alpha1.EndpointTypeReadOnly),
+			},
+		}
+	}
+	first, second := newEndpoint("replica-a"), newEndpoint("replica-b")
+	scheme := newTestScheme()
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(first, second).Build()
+	r := &EndpointReconciler{
+		Client:      k8sClient,
+		Scheme:      scheme,
+		NeonClients: neon.NewClientPool(k8sClient, server.Client(), nil),
+	}
+	ctx := context.Background()
+
+	reconcileUntilDone(t, r, first)
+	reconcileUntilDone(t, r, second)
+	for _, obj := range []*neontechv1alpha1.Endpoint{first, second} {
+		if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if first.Status.Id == "" || second.Status.Id == "" || first.Status.Id == second.Status.Id {
+		t.Errorf("endpoints have ids %q and %q, want two computes", first.Status.Id, second.Status.Id)
+	}
+}
+
+func TestAdoptedEndpointIsNotDeleted(t *testing.T) {
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("test")
+	var primary neon.Branch
+	for _, b := range server.Branches(projectId) {
+		if b.Primary {
+			primary = b
+		}
+	}
+	newEndpoint := func(name string) *neontechv1alpha1.Endpoint {
+		return &neontechv1alpha1.Endpoint{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: neontechv1alpha1.EndpointSpec{
+				BranchFrom: neontechv1alpha1.BranchFrom{ProjectId: projectId, BranchId: primary.Id},
+				Type:       string(neontechv1alpha1.EndpointTypeReadWrite),
+			},
+		}
+	}
+	endpoint := newEndpoint("compute")
+	scheme := newTestScheme()
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(endpoint).Build()
+	r := &EndpointReconciler{
+		Client:      k8sClient,
+		Scheme:      scheme,
+		NeonClients: neon.NewClientPool(k8sClient, server.Client(), nil),
+	}
+	ctx := context.Background()
+
+	// The endpoint was created outside the operator.
+	existing, err := server.Client().CreateEndpoint(ctx, k8sClient, newEndpoint("outside"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reconcileUntilDone(t, r, endpoint)
+	if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(endpoint), endpoint); err != nil {
+		t.Fatal(err)
+	}
+	if endpoint.Status.Id != existing.Endpoint.Id || !endpoint.Status.Adopted {
+		t.Fatalf("unexpected status of the adopted endpoint %+v", endpoint.Status)
+	}
+
+	if err := k8sClient.Delete(ctx, endpoint); err != nil {
+		t.Fatal(err)
+	}
+	reconcileUntilDone(t, r, endpoint)
+	if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(endpoint), endpoint); !kerrors.IsNotFound(err) {
+		t.Fatalf("expected the endpoint to be finalized, got %v", err)
+	}
+	if _, ok := server.Endpoint(projectId, existing.Endpoint.Id); !ok {
+		t.Error("the adopted endpoint was deleted in Neon")
+	}
+}
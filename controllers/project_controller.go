@@ -0,0 +1,263 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neontechv1alpha1 "github.com/evanshortiss/neon-kube-operator/api/v1alpha1"
+	"github.com/evanshortiss/neon-kube-operator/neon"
+)
+
+// ProjectReconciler reconciles a Project object
+type ProjectReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// NeonClients provides the Neon client for the credentials a project
+	// references.
+	NeonClients neon.ClientProvider
+
+	// Recorder records Neon API calls made for a project as Events on it.
+	Recorder record.EventRecorder
+	// AuditLog, if set, also receives the calls as JSON lines.
+	AuditLog *AuditLog
+}
+
+//+kubebuilder:rbac:groups=neon.tech,resources=projects,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=neon.tech,resources=projects/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=neon.tech,resources=projects/finalizers,verbs=update
+
+// Reconcile creates the Neon project of a Project resource and deletes it
+// when the resource is deleted.
+func (r *ProjectReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	ctx, span := startReconcileSpan(ctx, "Project", req)
+	result, err := r.reconcileRequest(ctx, req)
+	endReconcileSpan(span, result, err)
+	return result, err
+}
+
+// reconcileRequest does the work of Reconcile within its trace span.
+func (r *ProjectReconciler) reconcileRequest(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	p := &neontechv1alpha1.Project{}
+	err := r.Client.Get(ctx, req.NamespacedName, p)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			logger.Info("project resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	trace.SpanFromContext(ctx).SetAttributes(neon.ProjectIdKey.String(p.Status.Id))
+	ctx = withAuditor(ctx, r.Recorder, r.AuditLog, "Project", p)
+	if err = AddFinalizer(ctx, r.Client, p); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if p.DeletionTimestamp != nil {
+		_ = r.updateState(ctx, p, neontechv1alpha1.ProjectStateDeleting)
+		if err := r.ExecuteFinalizer(ctx, p); err != nil {
+			if errors.Is(err, neon.ErrRetryAgain) {
+				return ctrl.Result{Requeue: true, RequeueAfter: 5 * time.Second}, nil
+			}
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	err = r.reconcile(ctx, p)
+	if err != nil {
+		p.Status.Message = neon.ErrorMessage(err)
+	} else {
+		p.Status.Reset()
+	}
+	setDegraded(&p.Status.Conditions, p.Generation, err)
+
+	if updateErr := r.Status().Update(ctx, p); updateErr != nil {
+		return ctrl.Result{}, updateErr
+	}
+
+	if errors.Is(err, neon.ErrRetryAgain) {
+		return ctrl.Result{Requeue: true, RequeueAfter: 5 * time.Second}, nil
+	}
+	return ctrl.Result{}, err
+}
+
+// ExecuteFinalizer deletes the project in Neon, unless it was adopted.
+// Branches and endpoints of the project are deleted along with it.
+func (r *ProjectReconciler) ExecuteFinalizer(ctx context.Context, project *neontechv1alpha1.Project) error {
+	logger := log.FromContext(ctx)
+	if project.Status.Adopted {
+		logger.Info("Leaving adopted project in Neon", "name", project.Name, "id", project.Status.Id)
+	} else if project.Status.Id != "" {
+		logger.Info("Reconciling deletion of project", "name", project.Name)
+		neonClient, err := r.NeonClients.ClientFor(ctx, project.Namespace, project.Spec.CredentialsRef)
+		if err != nil {
+			return err
+		}
+		if _, err := neonClient.DeleteProject(ctx, project); err != nil {
+			return err
+		}
+	}
+	if ok := controllerutil.RemoveFinalizer(project, neonFinalizer); ok {
+		if err := r.Update(ctx, project); err != nil {
+			return err
+		}
+		logger.Info("Finalizer removed from project", "name", project.Name)
+	}
+	return nil
+}
+
+func (r *ProjectReconciler) reconcile(ctx context.Context, project *neontechv1alpha1.Project) error {
+	neonClient, err := r.NeonClients.ClientFor(ctx, project.Namespace, project.Spec.CredentialsRef)
+	if err != nil {
+		return err
+	}
+	operations := project.Status.PendingOperations
+	resp, err := neonClient.GetProject(ctx, project)
+	shouldCreate := false
+	if err != nil {
+		if !errors.Is(err, neon.ErrProjectNotFound) {
+			return err
+		}
+		shouldCreate = true
+	}
+	adopted := project.Status.Adopted
+	if shouldCreate {
+		resp, adopted, err = r.adoptOrCreate(ctx, neonClient, project)
+		if err != nil {
+			return err
+		}
+		operations = neon.OperationIds(resp.Operations)
+	}
+
+	previous := project.Status
+	project.Status = neon.NewProjectStatus(resp.Project)
+	project.Status.Conditions = previous.Conditions
+	project.Status.Adopted = adopted
+	if !shouldCreate {
+		project.Status.DefaultBranchId = previous.DefaultBranchId
+		project.Status.DefaultEndpointId = previous.DefaultEndpointId
+		project.Status.DefaultEndpointHost = previous.DefaultEndpointHost
+	}
+	if resp.Branch != nil {
+		project.Status.DefaultBranchId = resp.Branch.Id
+	}
+	setDefaultEndpoint(&project.Status, resp.Endpoints)
+	if project.Status.DefaultBranchId == "" || project.Status.DefaultEndpointId == "" {
+		if err := r.findDefaults(ctx, neonClient, project); err != nil {
+			return err
+		}
+	}
+
+	pending, err := neonClient.PendingOperations(ctx, project.Status.Id, operations)
+	project.Status.PendingOperations = pending
+	if err != nil || len(pending) > 0 {
+		project.Status.State = neontechv1alpha1.ProjectStateCreating
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("waiting for %d operations to finish, %w", len(pending), neon.ErrRetryAgain)
+	}
+	project.Status.State = neontechv1alpha1.ProjectStateCreated
+	return nil
+}
+
+// findDefaults looks up the default branch and endpoint of a project that
+// was adopted, since they are only returned when a project is created.
+func (r *ProjectReconciler) findDefaults(ctx context.Context, neonClient neon.ProjectClient, project *neontechv1alpha1.Project) error {
+	if project.Status.DefaultBranchId == "" {
+		branches, err := neonClient.ListBranches(ctx, project.Status.Id)
+		if err != nil {
+			return err
+		}
+		for _, b := range branches {
+			if b.Primary || b.Default {
+				project.Status.DefaultBranchId = b.Id
+			}
+		}
+	}
+	endpoints, err := neonClient.ListEndpoints(ctx, project.Status.Id)
+	if err != nil {
+		return err
+	}
+	setDefaultEndpoint(&project.Status, endpoints)
+	return nil
+}
+
+// setDefaultEndpoint records the read-write endpoint of the default branch
+// found in endpoints.
+func setDefaultEndpoint(status *neontechv1alpha1.ProjectStatus, endpoints []neon.Endpoint) {
+	for _, e := range endpoints {
+		if e.BranchId == status.DefaultBranchId && e.Type == string(neontechv1alpha1.EndpointTypeReadWrite) {
+			status.DefaultEndpointId = e.Id
+			status.DefaultEndpointHost = e.Host
+		}
+	}
+}
+
+// adoptOrCreate creates the project in Neon. A project that has never been
+// created by this resource first applies the adoption policy to an existing
+// project with the same name. It reports whether the project was adopted.
+func (r *ProjectReconciler) adoptOrCreate(ctx context.Context, neonClient neon.ProjectClient, project *neontechv1alpha1.Project) (*neon.ProjectResponse, bool, error) {
+	logger := log.FromContext(ctx)
+	if project.Status.Id == "" && project.Spec.AdoptionPolicy != neontechv1alpha1.AdoptionPolicyCreate {
+		owned, err := ownedProjectIds(ctx, r.Client, project)
+		if err != nil {
+			return nil, false, err
+		}
+		existing, err := neonClient.FindProject(ctx, project, owned)
+		switch {
+		case err == nil && project.Spec.AdoptionPolicy == neontechv1alpha1.AdoptionPolicyFail:
+			return nil, false, fmt.Errorf("project %s already exists with id %s", existing.Name, existing.Id)
+		case err == nil:
+			logger.Info("Adopting existing project", "name", project.Name, "id", existing.Id)
+			return &neon.ProjectResponse{Project: *existing}, true, nil
+		case !errors.Is(err, neon.ErrProjectNotFound):
+			return nil, false, err
+		}
+	}
+	logger.Info("Creating project", "name", project.Name)
+	resp, err := neonClient.CreateProject(ctx, project)
+	return resp, false, err
+}
+
+func (r *ProjectReconciler) updateState(ctx context.Context, project *neontechv1alpha1.Project, state neontechv1alpha1.ProjectState) error {
+	project.Status.State = state
+	return r.Client.Status().Update(ctx, project)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ProjectReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&neontechv1alpha1.Project{}).
+		Complete(r)
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	neontechv1alpha1 "github.com/evanshortiss/neon-kube-operator/api/v1alpha1"
+	"github.com/evanshortiss/neon-kube-operator/neon"
+	"github.com/evanshortiss/neon-kube-operator/neon/neontest"
+)
+
+func newTestScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = neontechv1alpha1.AddToScheme(scheme)
+	return scheme
+}
+
+// reconcileUntilDone reconciles obj until no requeue is asked for.
+func reconcileUntilDone(t *testing.T, r interface {
+	Reconcile(context.Context, ctrl.Request) (ctrl.Result, error)
+}, obj client.Object) {
+	t.Helper()
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(obj)}
+	for i := 0; i < 5; i++ {
+		result, err := r.Reconcile(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Reconcile %s: %v", req, err)
+		}
+		if !result.Requeue && result.RequeueAfter == 0 {
+			return
+		}
+	}
+	t.Fatalf("Reconcile %s kept requeueing", req)
+}
+
+func TestProjectAndBranchRef(t *testing.T) {
+	server := neontest.NewServer(t)
+	project := &neontechv1alpha1.Project{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec:       neontechv1alpha1.ProjectSpec{AdoptionPolicy: neontechv1alpha1.AdoptionPolicyAdopt},
+	}
+	branch := &neontechv1alpha1.Branch{
+		ObjectMeta: metav1.ObjectMeta{Name: "feature", Namespace: "default"},
+		Spec:       neontechv1alpha1.BranchSpec{ProjectRef: "app"},
+	}
+	scheme := newTestScheme()
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(project, branch).Build()
+	clients := neon.NewClientPool(k8sClient, server.Client(), nil)
+
+	reconcileUntilDone(t, &ProjectReconciler{Client: k8sClient, Scheme: scheme, NeonClients: clients}, project)
+	ctx := context.Background()
+	if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(project), project); err != nil {
+		t.Fatal(err)
+	}
+	status := project.Status
+	if status.State != neontechv1alpha1.ProjectStateCreated || status.Id == "" ||
+		status.DefaultBranchId == "" || status.DefaultEndpointHost == "" {
+		t.Fatalf("unexpected project status %+v", status)
+	}
+	if _, ok := server.Branch(status.Id, status.DefaultBranchId); !ok {
+		t.Errorf("default branch %s not found in Neon", status.DefaultBranchId)
+	}
+
+	reconcileUntilDone(t, &BranchReconciler{Client: k8sClient, Scheme: scheme, NeonClients: clients}, branch)
+	if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(branch), branch); err != nil {
+		t.Fatal(err)
+	}
+	if branch.Status.ProjectId != status.Id || branch.Status.State != neontechv1alpha1.BranchStateCreated {
+		t.Errorf("unexpected branch status %+v", branch.Status)
+	}
+	if _, ok := server.Branch(status.Id, branch.Status.Id); !ok {
+		t.Errorf("branch %s not found in project %s", branch.Status.Id, status.Id)
+	}
+}
+
+func TestAdoptedProjectIsNotDeleted(t *testing.T) {
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("legacy")
+	project := &neontechv1alpha1.Project{
+		ObjectMeta: metav1.ObjectMeta{Name: "legacy", Namespace: "default"},
+		Spec:       neontechv1alpha1.ProjectSpec{AdoptionPolicy: neontechv1alpha1.AdoptionPolicyAdopt},
+	}
+	scheme := newTestScheme()
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(project).Build()
+	r := &ProjectReconciler{
+		Client:      k8sClient,
+		Scheme:      scheme,
+		NeonClients: neon.NewClientPool(k8sClient, server.Client(), nil),
+	}
+	ctx := context.Background()
+
+	reconcileUntilDone(t, r, project)
+	if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(project), project); err != nil {
+		t.Fatal(err)
+	}
+	if !project.Status.Adopted || project.Status.Id != projectId {
+		t.Fatalf("project was not adopted: %+v", project.Status)
+	}
+
+	if err := k8sClient.Delete(ctx, project); err != nil {
+		t.Fatal(err)
+	}
+	reconcileUntilDone(t, r, project)
+	if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(project), project); err == nil {
+		t.Error("finalizer was not removed")
+	}
+	server.ExpectRequests(t, "DELETE", "/projects/*", 0)
+	if _, err := server.Client().GetProject(ctx, project); err != nil {
+		t.Errorf("adopted project is gone from Neon: %v", err)
+	}
+}
+
+func TestProjectDoesNotAdoptOwnedProject(t *testing.T) {
+	server := neontest.NewServer(t)
+	newProject := func(namespace string) *neontechv1alpha1.Project {
+		return &neontechv1alpha1.Project{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: namespace},
+			Spec:       neontechv1alpha1.ProjectSpec{AdoptionPolicy: neontechv1alpha1.AdoptionPolicyAdopt},
+		}
+	}
+	first, second := newProject("team-a"), newProject("team-b")
+	scheme := newTestScheme()
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(first, second).Build()
+	r := &ProjectReconciler{
+		Client:      k8sClient,
+		Scheme:      scheme,
+		NeonClients: neon.NewClientPool(k8sClient, server.Client(), nil),
+	}
+	ctx := context.Background()
+
+	reconcileUntilDone(t, r, first)
+	reconcileUntilDone(t, r, second)
+	for _, obj := range []*neontechv1alpha1.Project{first, second} {
+		if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if first.Status.Adopted || second.Status.Adopted || first.Status.Id == second.Status.Id {
+		t.Fatalf("projects have statuses %+v and %+v, want two created projects", first.Status, second.Status)
+	}
+
+	// Deleting the first resource leaves the project of the second alone.
+	if err := k8sClient.Delete(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	reconcileUntilDone(t, r, first)
+	if _, err := server.Client().GetProject(ctx, second); err != nil {
+		t.Errorf("project of the second resource is gone from Neon: %v", err)
+	}
+}
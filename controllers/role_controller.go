@@ -0,0 +1,304 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neontechv1alpha1 "github.com/evanshortiss/neon-kube-operator/api/v1alpha1"
+	"github.com/evanshortiss/neon-kube-operator/neon"
+)
+
+const (
+	roleSecretUsernameField = "username"
+	roleSecretPasswordField = "password"
+)
+
+// RoleReconciler reconciles a Role object
+type RoleReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// NeonClients provides the Neon client for the credentials a role
+	// references.
+	NeonClients neon.ClientProvider
+
+	// Recorder records Neon API calls made for a role as Events on it.
+	Recorder record.EventRecorder
+	// AuditLog, if set, also receives the calls as JSON lines.
+	AuditLog *AuditLog
+}
+
+//+kubebuilder:rbac:groups=neon.tech,resources=roles,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=neon.tech,resources=roles/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=neon.tech,resources=roles/finalizers,verbs=update
+
+// Reconcile creates the Postgres role of a Role resource on its branch and
+// writes its credentials to a Secret. The password is reset when the
+// password revision of the resource is increased, and the role is deleted
+// along with the resource.
+func (r *RoleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	ctx, span := startReconcileSpan(ctx, "Role", req)
+	result, err := r.reconcileRequest(ctx, req)
+	endReconcileSpan(span, result, err)
+	return result, err
+}
+
+// reconcileRequest does the work of Reconcile within its trace span.
+func (r *RoleReconciler) reconcileRequest(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	role := &neontechv1alpha1.Role{}
+	err := r.Client.Get(ctx, req.NamespacedName, role)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			logger.Info("role resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	trace.SpanFromContext(ctx).SetAttributes(
+		neon.ProjectIdKey.String(role.Status.ProjectId),
+		neon.BranchIdKey.String(role.Status.BranchId),
+	)
+	ctx = withAuditor(ctx, r.Recorder, r.AuditLog, "Role", role)
+	if err = AddFinalizer(ctx, r.Client, role); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if role.DeletionTimestamp != nil {
+		_ = r.updateState(ctx, role, neontechv1alpha1.RoleStateDeleting)
+		if err := r.ExecuteFinalizer(ctx, role); err != nil {
+			if errors.Is(err, neon.ErrRetryAgain) {
+				return ctrl.Result{Requeue: true, RequeueAfter: 5 * time.Second}, nil
+			}
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	err = r.reconcile(ctx, role)
+	if err != nil {
+		role.Status.Message = neon.ErrorMessage(err)
+	} else {
+		role.Status.Reset()
+	}
+	setDegraded(&role.Status.Conditions, role.Generation, err)
+
+	if updateErr := r.Status().Update(ctx, role); updateErr != nil {
+		return ctrl.Result{}, updateErr
+	}
+
+	if errors.Is(err, neon.ErrRetryAgain) {
+		return ctrl.Result{Requeue: true, RequeueAfter: 5 * time.Second}, nil
+	}
+	return ctrl.Result{}, err
+}
+
+// ExecuteFinalizer deletes the role in Neon unless it was adopted and is
+// not managed, then removes the finalizer. The Secret is owned by the
+// resource and garbage collected with it.
+func (r *RoleReconciler) ExecuteFinalizer(ctx context.Context, role *neontechv1alpha1.Role) error {
+	logger := log.FromContext(ctx)
+	if !role.Managed() {
+		logger.Info("Leaving adopted role in Neon", "name", role.Name, "role", role.Status.Name)
+	} else if role.Status.Name != "" && role.Status.BranchId != "" {
+		logger.Info("Reconciling deletion of role", "name", role.Name)
+		neonClient, err := r.NeonClients.ClientFor(ctx, role.Namespace, role.Spec.CredentialsRef)
+		if err != nil {
+			return err
+		}
+		if _, err := neonClient.DeleteRole(ctx, role); err != nil {
+			return err
+		}
+	}
+	if ok := controllerutil.RemoveFinalizer(role, neonFinalizer); ok {
+		if err := r.Update(ctx, role); err != nil {
+			return err
+		}
+		logger.Info("Finalizer removed from role", "name", role.Name)
+	}
+	return nil
+}
+
+func (r *RoleReconciler) reconcile(ctx context.Context, role *neontechv1alpha1.Role) error {
+	logger := log.FromContext(ctx)
+	neonClient, err := r.NeonClients.ClientFor(ctx, role.Namespace, role.Spec.CredentialsRef)
+	if err != nil {
+		return err
+	}
+	err = resolveBranchOnce(ctx, r.Client, role.Namespace, role.Spec.BranchFrom, &role.Status.BranchId, &role.Status.ProjectId)
+	if err != nil {
+		return err
+	}
+	if role.Status.Name != "" && role.Status.Name != role.NeonName() {
+		return fmt.Errorf("role %s cannot be renamed to %s", role.Status.Name, role.NeonName())
+	}
+
+	operations := role.Status.PendingOperations
+	// password is set when the role is created or its password is reset,
+	// and is the only chance to learn it without revealing it.
+	var password string
+	resp, err := neonClient.GetRole(ctx, role)
+	switch {
+	case errors.Is(err, neon.ErrRoleNotFound):
+		logger.Info("Creating role", "name", role.Name)
+		resp, err = neonClient.CreateRole(ctx, role)
+		if err != nil {
+			return err
+		}
+		operations = neon.OperationIds(resp.Operations)
+		password = resp.Role.Password
+	case err != nil:
+		return err
+	case role.Status.Name == "":
+		// Role names are unique on a branch, so a role that exists before
+		// this resource created it can only be adopted.
+		if role.Spec.AdoptionPolicy == neontechv1alpha1.AdoptionPolicyFail || role.Spec.AdoptionPolicy == neontechv1alpha1.AdoptionPolicyCreate {
+			return fmt.Errorf("role %s already exists on branch %s", resp.Role.Name, resp.Role.BranchId)
+		}
+		owned, err := ownedRoleNames(ctx, r.Client, role)
+		if err != nil {
+			return err
+		}
+		if owned[resp.Role.BranchId+"/"+resp.Role.Name] {
+			return fmt.Errorf("role %s on branch %s belongs to another Role resource", resp.Role.Name, resp.Role.BranchId)
+		}
+		logger.Info("Adopting existing role", "name", role.Name, "role", resp.Role.Name)
+		role.Status.Adopted = true
+	case !role.Managed():
+		// The password of a role the operator does not own is left alone.
+	case role.Spec.PasswordRevision > role.Status.PasswordRevision:
+		logger.Info("Resetting role password", "name", role.Name, "revision", role.Spec.PasswordRevision)
+		resp, err = neonClient.ResetRolePassword(ctx, role)
+		if err != nil {
+			return err
+		}
+		operations = append(operations, neon.OperationIds(resp.Operations)...)
+		password = resp.Role.Password
+	}
+	r.setStatus(role, resp.Role)
+
+	if role.Managed() {
+		if err := r.reconcileSecret(ctx, neonClient, role, password); err != nil {
+			return err
+		}
+		role.Status.SecretName = role.SecretName()
+		role.Status.PasswordRevision = role.Spec.PasswordRevision
+	}
+
+	pending, err := neonClient.PendingOperations(ctx, role.Status.ProjectId, operations)
+	role.Status.PendingOperations = pending
+	if err != nil || len(pending) > 0 {
+		role.Status.State = neontechv1alpha1.RoleStateCreating
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("waiting for %d operations to finish, %w", len(pending), neon.ErrRetryAgain)
+	}
+	role.Status.State = neontechv1alpha1.RoleStateCreated
+	return nil
+}
+
+// setStatus replaces the status with the observed role, keeping the fields
+// that Neon does not return.
+func (r *RoleReconciler) setStatus(role *neontechv1alpha1.Role, observed neon.Role) {
+	previous := role.Status
+	role.Status = neon.NewRoleStatus(observed)
+	role.Status.State = previous.State
+	role.Status.ProjectId = previous.ProjectId
+	role.Status.SecretName = previous.SecretName
+	role.Status.PasswordRevision = previous.PasswordRevision
+	role.Status.Adopted = previous.Adopted
+	role.Status.PendingOperations = previous.PendingOperations
+	role.Status.Conditions = previous.Conditions
+}
+
+// reconcileSecret writes the credentials of the role to its Secret. The
+// password is revealed from Neon if it was not just set and the Secret does
+// not hold it yet.
+func (r *RoleReconciler) reconcileSecret(ctx context.Context, neonClient neon.RoleClient, role *neontechv1alpha1.Role, password string) error {
+	logger := log.FromContext(ctx)
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      role.SecretName(),
+			Namespace: role.Namespace,
+		},
+	}
+	if password == "" {
+		err := r.Client.Get(ctx, client.ObjectKeyFromObject(secret), secret)
+		if err != nil && !kerrors.IsNotFound(err) {
+			return err
+		}
+		if len(secret.Data[roleSecretPasswordField]) == 0 {
+			password, err = neonClient.GetRolePassword(ctx, role.Status.ProjectId, role.Status.BranchId, role.Status.Name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		result, err := CreateOrUpdate(ctx, r.Client, secret, func() error {
+			if err := controllerutil.SetControllerReference(role, secret, r.Scheme); err != nil {
+				return fmt.Errorf("failed to set owner reference on Secret: %w", err)
+			}
+			if secret.Data == nil {
+				secret.Data = make(map[string][]byte)
+			}
+			secret.Data[roleSecretUsernameField] = []byte(role.Status.Name)
+			if password != "" {
+				secret.Data[roleSecretPasswordField] = []byte(password)
+			}
+			return nil
+		})
+		if result != controllerutil.OperationResultNone {
+			logger.Info("Operation result", "result", result)
+		}
+		return err
+	})
+}
+
+func (r *RoleReconciler) updateState(ctx context.Context, role *neontechv1alpha1.Role, state neontechv1alpha1.RoleState) error {
+	role.Status.State = state
+	return r.Client.Status().Update(ctx, role)
+}
+
+// SetupWithManager sets up the controller with the Manager. Secrets of
+// roles are watched, so that a deleted Secret is written again.
+func (r *RoleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&neontechv1alpha1.Role{}).
+		Owns(&v1.Secret{}).
+		Complete(r)
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	neontechv1alpha1 "github.com/evanshortiss/neon-kube-operator/api/v1alpha1"
+	"github.com/evanshortiss/neon-kube-operator/neon"
+	"github.com/evanshortiss/neon-kube-operator/neon/neontest"
+)
+
+func TestRoleLifecycle(t *testing.T) {
+	server := neontest.NewServer(t)
+	neonClient := server.Client()
+	projectId := server.AddProject("shop")
+	var branchId string
+	for _, b := range server.Branches(projectId) {
+		if b.Primary {
+			branchId = b.Id
+		}
+	}
+
+	role := &neontechv1alpha1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "default"},
+		Spec: neontechv1alpha1.RoleSpec{
+			BranchFrom: neontechv1alpha1.BranchFrom{ProjectId: projectId, BranchId: branchId},
+			Name:       "orders_app",
+		},
+	}
+	scheme := newTestScheme()
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(role).Build()
+	r := &RoleReconciler{
+		Client:      k8sClient,
+		Scheme:      scheme,
+		NeonClients: neon.NewClientPool(k8sClient, neonClient, nil),
+	}
+	ctx := context.Background()
+	// checkSecret verifies the Secret holds the current password of the
+	// role, and returns it.
+	checkSecret := func() string {
+		t.Helper()
+		secret := &v1.Secret{}
+		if err := k8sClient.Get(ctx, types.NamespacedName{Name: "neon-role-orders", Namespace: "default"}, secret); err != nil {
+			t.Fatal(err)
+		}
+		want, err := neonClient.GetRolePassword(ctx, projectId, branchId, "orders_app")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(secret.Data["username"]); got != "orders_app" {
+			t.Errorf("Secret username = %q", got)
+		}
+		if got := string(secret.Data["password"]); got != want {
+			t.Errorf("Secret password = %q, want %q", got, want)
+		}
+		return want
+	}
+
+	reconcileUntilDone(t, r, role)
+	if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(role), role); err != nil {
+		t.Fatal(err)
+	}
+	if s := role.Status; s.State != neontechv1alpha1.RoleStateCreated || s.Name != "orders_app" ||
+		s.SecretName != "neon-role-orders" || s.BranchId != branchId {
+		t.Fatalf("unexpected status after create %+v", s)
+	}
+	first := checkSecret()
+
+	role.Spec.PasswordRevision = 1
+	if err := k8sClient.Update(ctx, role); err != nil {
+		t.Fatal(err)
+	}
+	reconcileUntilDone(t, r, role)
+	if second := checkSecret(); second == first {
+		t.Error("password was not reset")
+	}
+	// A reconcile without a new revision leaves the password alone.
+	reconcileUntilDone(t, r, role)
+	server.ExpectRequests(t, "POST", "/projects/*/branches/*/roles/*/reset_password", 1)
+
+	if err := k8sClient.Delete(ctx, role); err != nil {
+		t.Fatal(err)
+	}
+	reconcileUntilDone(t, r, role)
+	for _, existing := range server.Roles(projectId, branchId) {
+		if existing.Name == "orders_app" {
+			t.Error("role was not deleted")
+		}
+	}
+}
+
+func TestAdoptedRoleIsLeftAlone(t *testing.T) {
+	server := neontest.NewServer(t)
+	neonClient := server.Client()
+	projectId := server.AddProject("shop")
+	var branchId string
+	for _, b := range server.Branches(projectId) {
+		if b.Primary {
+			branchId = b.Id
+		}
+	}
+	ctx := context.Background()
+	password, err := neonClient.GetRolePassword(ctx, projectId, branchId, "shop_owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	role := &neontechv1alpha1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: "owner", Namespace: "default"},
+		Spec: neontechv1alpha1.RoleSpec{
+			BranchFrom:       neontechv1alpha1.BranchFrom{ProjectId: projectId, BranchId: branchId},
+			Name:             "shop_owner",
+			PasswordRevision: 1,
+			AdoptionPolicy:   neontechv1alpha1.AdoptionPolicyAdopt,
+		},
+	}
+	scheme := newTestScheme()
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(role).Build()
+	r := &RoleReconciler{
+		Client:      k8sClient,
+		Scheme:      scheme,
+		NeonClients: neon.NewClientPool(k8sClient, neonClient, nil),
+	}
+
+	reconcileUntilDone(t, r, role)
+	// A second reconcile must not reset the password either.
+	reconcileUntilDone(t, r, role)
+	if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(role), role); err != nil {
+		t.Fatal(err)
+	}
+	if s := role.Status; !s.Adopted || s.State != neontechv1alpha1.RoleStateCreated || s.SecretName != "" {
+		t.Fatalf("unexpected status after adoption %+v", s)
+	}
+	server.ExpectRequests(t, "POST", "/projects/*/branches/*/roles/*/reset_password", 0)
+	secret := &v1.Secret{}
+	if err := k8sClient.Get(ctx, types.NamespacedName{Name: "neon-role-owner", Namespace: "default"}, secret); err == nil {
+		t.Error("Secret was written for an adopted role")
+	}
+
+	if err := k8sClient.Delete(ctx, role); err != nil {
+		t.Fatal(err)
+	}
+	reconcileUntilDone(t, r, role)
+	server.ExpectRequests(t, "DELETE", "/projects/*/branches/*/roles/*", 0)
+	if got, err := neonClient.GetRolePassword(ctx, projectId, branchId, "shop_owner"); err != nil || got != password {
+		t.Errorf("adopted role changed in Neon: %q, %v", got, err)
+	}
+}
+
+func TestManagedAdoptedRole(t *testing.T) {
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("shop")
+	var branchId string
+	for _, b := range server.Branches(projectId) {
+		if b.Primary {
+			branchId = b.Id
+		}
+	}
+	role := &neontechv1alpha1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: "owner", Namespace: "default"},
+		Spec: neontechv1alpha1.RoleSpec{
+			BranchFrom:       neontechv1alpha1.BranchFrom{ProjectId: projectId, BranchId: branchId},
+			Name:             "shop_owner",
+			PasswordRevision: 1,
+			AdoptionPolicy:   neontechv1alpha1.AdoptionPolicyAdopt,
+			ManageAdopted:    true,
+		},
+	}
+	scheme := newTestScheme()
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(role).Build()
+	r := &RoleReconciler{
+		Client:      k8sClient,
+		Scheme:      scheme,
+		NeonClients: neon.NewClientPool(k8sClient, server.Client(), nil),
+	}
+	ctx := context.Background()
+
+	reconcileUntilDone(t, r, role)
+	if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(role), role); err != nil {
+		t.Fatal(err)
+	}
+	if s := role.Status; !s.Adopted || s.SecretName != "neon-role-owner" || s.PasswordRevision != 1 {
+		t.Fatalf("unexpected status after adoption %+v", s)
+	}
+	role.Spec.PasswordRevision = 2
+	if err := k8sClient.Update(ctx, role); err != nil {
+		t.Fatal(err)
+	}
+	reconcileUntilDone(t, r, role)
+	server.ExpectRequests(t, "POST", "/projects/*/branches/*/roles/*/reset_password", 1)
+
+	if err := k8sClient.Delete(ctx, role); err != nil {
+		t.Fatal(err)
+	}
+	reconcileUntilDone(t, r, role)
+	server.ExpectRequests(t, "DELETE", "/projects/*/branches/*/roles/*", 1)
+}
+
+func TestRoleDoesNotAdoptOwnedRole(t *testing.T) {
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("shop")
+	var branchId string
+	for _, b := range server.Branches(projectId) {
+		if b.Primary {
+			branchId = b.Id
+		}
+	}
+	newRole := func(namespace string) *neontechv1alpha1.Role {
+		return &neontechv1alpha1.Role{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: namespace},
+			Spec: neontechv1alpha1.RoleSpec{
+				BranchFrom: neontechv1alpha1.BranchFrom{ProjectId: projectId, BranchId: branchId},
+			},
+		}
+	}
+	first, second := newRole("team-a"), newRole("team-b")
+	scheme := newTestScheme()
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(first, second).Build()
+	r := &RoleReconciler{
+		Client:      k8sClient,
+		Scheme:      scheme,
+		NeonClients: neon.NewClientPool(k8sClient, server.Client(), nil),
+	}
+	ctx := context.Background()
+
+	reconcileUntilDone(t, r, first)
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(second)}); err == nil {
+		t.Fatal("expected the second resource to fail to adopt the role")
+	}
+	if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(second), second); err != nil {
+		t.Fatal(err)
+	}
+	if second.Status.Adopted || !strings.Contains(second.Status.Message, "another Role resource") {
+		t.Errorf("unexpected status of the second resource %+v", second.Status)
+	}
+}
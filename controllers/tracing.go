@@ -0,0 +1,54 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/evanshortiss/neon-kube-operator/neon/redact"
+)
+
+// tracer uses the global tracer provider, so spans are dropped unless
+// tracing is enabled in main.
+var tracer = otel.Tracer("github.com/evanshortiss/neon-kube-operator/controllers")
+
+// startReconcileSpan starts the span covering one reconcile of kind.
+func startReconcileSpan(ctx context.Context, kind string, req ctrl.Request) (context.Context, trace.Span) {
+	return tracer.Start(ctx, kind+"Reconciler.Reconcile", trace.WithAttributes(
+		attribute.String("k8s.namespace.name", req.Namespace),
+		attribute.String("k8s.resource.name", req.Name),
+	))
+}
+
+// endReconcileSpan records the outcome of a reconcile and ends span.
+func endReconcileSpan(span trace.Span, result ctrl.Result, err error) {
+	span.SetAttributes(
+		attribute.Bool("reconcile.requeue", result.Requeue || result.RequeueAfter > 0),
+		attribute.Int64("reconcile.requeue_after_ms", result.RequeueAfter.Milliseconds()),
+	)
+	if err != nil {
+		span.RecordError(redact.Error(err))
+		span.SetStatus(codes.Error, redact.String(err.Error()))
+	}
+	span.End()
+}
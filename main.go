@@ -18,17 +18,26 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
-	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
-	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -38,6 +47,7 @@ import (
 	neontechv1alpha1 "github.com/evanshortiss/neon-kube-operator/api/v1alpha1"
 	"github.com/evanshortiss/neon-kube-operator/controllers"
 	"github.com/evanshortiss/neon-kube-operator/neon"
+	"github.com/evanshortiss/neon-kube-operator/neon/redact"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -47,9 +57,8 @@ var (
 )
 
 const (
-	neonOperatorNs         = "neon-operator"
-	neonSecretName         = "neon-operator-secrets"
-	neonApiKeyPropertyName = "neon-api-key"
+	defaultSecretNamespace = "neon-operator"
+	defaultSecretName      = "neon-operator-secrets"
 )
 
 func init() {
@@ -63,19 +72,97 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var neonAPIURL string
+	var neonUserAgent string
+	var neonCAFile string
+	var neonTimeout time.Duration
+	var neonMaxAttempts int
+	var neonRateLimit neon.RateLimit
+	var neonCacheResync time.Duration
+	var neonBreakerThreshold int
+	var neonBreakerOpenDuration time.Duration
+	var neonRequireAPIKey bool
+	var neonSecretNamespace string
+	var neonSecretName string
+	var neonSecretKey string
+	var neonAPIKeyFile string
+	var auditLogPath string
+	var otlpEndpoint string
+	var otlpInsecure bool
+	var traceSampleRatio float64
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&neonAPIURL, "neon-api-url", neon.DefaultBaseURL, "The base URL of the Neon API.")
+	flag.StringVar(&neonUserAgent, "neon-user-agent", neon.DefaultUserAgent, "The User-Agent sent with Neon API requests.")
+	flag.StringVar(&neonCAFile, "neon-ca-file", "",
+		"Path to a PEM encoded CA bundle used to verify the Neon API. "+
+			"Defaults to the system roots.")
+	flag.DurationVar(&neonTimeout, "neon-api-timeout", 30*time.Second,
+		"The time limit for each Neon API call, including retries.")
+	flag.IntVar(&neonMaxAttempts, "neon-api-max-attempts", neon.DefaultRetryPolicy.MaxAttempts,
+		"The number of attempts made for Neon API requests that fail with a transient error.")
+	flag.Float64Var(&neonRateLimit.QPS, "neon-api-qps", neon.DefaultRateLimit.QPS,
+		"The maximum rate of Neon API requests per second, for each API key. Zero disables the limit.")
+	flag.IntVar(&neonRateLimit.Burst, "neon-api-burst", neon.DefaultRateLimit.Burst,
+		"The number of Neon API requests allowed above the rate in a burst.")
+	flag.Float64Var(&neonRateLimit.ProjectQPS, "neon-api-project-qps", neon.DefaultRateLimit.ProjectQPS,
+		"The maximum rate of Neon API requests per second for a single project, across all API keys. Zero disables the limit.")
+	flag.IntVar(&neonRateLimit.ProjectBurst, "neon-api-project-burst", neon.DefaultRateLimit.ProjectBurst,
+		"The number of Neon API requests for a single project allowed above the rate in a burst.")
+	flag.IntVar(&neonBreakerThreshold, "neon-breaker-threshold", 5,
+		"The number of consecutive failed Neon API calls after which calls are paused and the operator "+
+			"reports not ready. Zero disables the circuit breaker.")
+	flag.DurationVar(&neonBreakerOpenDuration, "neon-breaker-open-duration", 30*time.Second,
+		"How long Neon API calls are paused before a call is made to check whether the API has recovered.")
+	flag.DurationVar(&neonCacheResync, "neon-cache-resync", time.Minute,
+		"How often branches and endpoints are listed per Neon project to serve reads from memory. "+
+			"Zero disables the cache.")
+	// The default Neon API key is read from the first source that is set:
+	// --neon-api-key-file, the NEON_API_KEY env var, or the operator Secret.
+	// Each flag below falls back to its env var, then to the default.
+	flag.StringVar(&neonSecretNamespace, "neon-secret-namespace",
+		envOrDefault("NEON_SECRET_NAMESPACE", defaultSecretNamespace),
+		"The namespace of the Secret holding the default Neon API key. Env: NEON_SECRET_NAMESPACE.")
+	flag.StringVar(&neonSecretName, "neon-secret-name",
+		envOrDefault("NEON_SECRET_NAME", defaultSecretName),
+		"The name of the Secret holding the default Neon API key. Env: NEON_SECRET_NAME.")
+	flag.StringVar(&neonSecretKey, "neon-secret-key",
+		envOrDefault("NEON_SECRET_KEY", neon.DefaultCredentialsKey),
+		"The key of the default Neon API key in its Secret. Env: NEON_SECRET_KEY.")
+	flag.StringVar(&neonAPIKeyFile, "neon-api-key-file", os.Getenv("NEON_API_KEY_FILE"),
+		"Path to a file holding the default Neon API key, such as a mounted Secret. "+
+			"Takes precedence over NEON_API_KEY and the Secret. Env: NEON_API_KEY_FILE.")
+	flag.BoolVar(&neonRequireAPIKey, "neon-require-api-key", true,
+		"Report not ready while the default Neon API key is missing or rejected. "+
+			"Disable if every resource references its own credentials.")
+	flag.StringVar(&auditLogPath, "audit-log", "",
+		"Path to a file that Neon API calls changing resources are appended to as JSON lines, "+
+			"or - for stdout. Calls are always recorded as Events on the resources.")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "",
+		"The host:port of an OTLP gRPC collector to export traces to. Tracing is disabled if empty.")
+	flag.BoolVar(&otlpInsecure, "otlp-insecure", false, "Connect to the OTLP collector without TLS.")
+	flag.Float64Var(&traceSampleRatio, "trace-sample-ratio", 1,
+		"The fraction of reconciles that are traced, between 0 and 1.")
 	opts := zap.Options{
 		Development: true,
+		// Connection strings and API keys can end up in errors, mask them
+		// before they are written.
+		DestWriter: redact.NewWriter(os.Stderr),
 	}
 	opts.BindFlags(flag.CommandLine)
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	shutdownTracing, err := setupTracing(otlpEndpoint, otlpInsecure, traceSampleRatio)
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		os.Exit(1)
+	}
+
 	cfg := ctrl.GetConfigOrDie()
 	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
 		Scheme:                 scheme,
@@ -101,41 +188,127 @@ func main() {
 		os.Exit(1)
 	}
 
-	clientset, err := kubernetes.NewForConfig(cfg)
+	transport, err := neonTransport(neonCAFile)
 	if err != nil {
-		setupLog.Error(err, "unable to create client")
+		setupLog.Error(err, "unable to configure neon api transport")
 		os.Exit(1)
 	}
-	// TODO: parameterise secret namespace and name?
-	secret, err := clientset.CoreV1().Secrets(neonOperatorNs).Get(context.Background(), neonSecretName, v1.GetOptions{})
+	clientOpts := []neon.ClientOption{
+		neon.WithBaseURL(neonAPIURL),
+		neon.WithUserAgent(neonUserAgent),
+		neon.WithTransport(transport),
+		neon.WithTimeout(neonTimeout),
+		neon.WithRetryPolicy(neon.RetryPolicy{
+			MaxAttempts: neonMaxAttempts,
+			BaseDelay:   neon.DefaultRetryPolicy.BaseDelay,
+			MaxDelay:    neon.DefaultRetryPolicy.MaxDelay,
+		}),
+		// All clients share the rate limiter. Each API key has a limit of
+		// its own, so a busy namespace cannot starve the others, and the
+		// limit of a project applies whichever key it is called with.
+		neon.WithRateLimiter(neon.NewRateLimiter(neonRateLimit)),
+	}
+	// All clients share one breaker, an outage affects every API key.
+	var neonBreaker *neon.CircuitBreaker
+	if neonBreakerThreshold > 0 {
+		neonBreaker = neon.NewCircuitBreaker(neonBreakerThreshold, neonBreakerOpenDuration)
+		clientOpts = append(clientOpts, neon.WithCircuitBreaker(neonBreaker))
+	}
+	// Every API key, the default one and those referenced by resources,
+	// gets its own client and cache.
+	newNeonAPI := func(apiKey string) neon.API {
+		client := neon.CreateClient(apiKey, clientOpts...)
+		if neonCacheResync > 0 {
+			return neon.NewCache(client, neonCacheResync)
+		}
+		return client
+	}
+	// The default client starts without a key, it is loaded from one of the
+	// sources below and replaced whenever the source changes.
+	defaultClient := neon.CreateClient("", clientOpts...)
+	var defaultNeonAPI neon.API = defaultClient
+	if neonCacheResync > 0 {
+		defaultNeonAPI = neon.NewCache(defaultClient, neonCacheResync)
+	}
+	if err := addAPIKeySource(mgr, defaultClient, controllers.APIKeySources{
+		File:   neonAPIKeyFile,
+		Env:    os.Getenv("NEON_API_KEY"),
+		Secret: types.NamespacedName{Namespace: neonSecretNamespace, Name: neonSecretName},
+		Key:    neonSecretKey,
+	}); err != nil {
+		setupLog.Error(err, "unable to load the neon api key")
+		os.Exit(1)
+	}
+	neonClients := neon.NewClientPool(mgr.GetClient(), defaultNeonAPI, newNeonAPI)
+	if err := mgr.Add(neonClients); err != nil {
+		setupLog.Error(err, "unable to add neon client pool")
+		os.Exit(1)
+	}
+	auditLog, err := openAuditLog(auditLogPath)
 	if err != nil {
-		setupLog.Error(err, "unable to read neon-api-key secret")
+		setupLog.Error(err, "unable to open audit log")
 		os.Exit(1)
 	}
-
-	apiKey, exists := secret.Data[neonApiKeyPropertyName]
-	if !exists {
-		setupLog.Error(err, fmt.Sprintf("key named '%s' is missing in '%s' secret", neonApiKeyPropertyName, neonSecretName))
+	if err = (&controllers.ProjectReconciler{
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		NeonClients: neonClients,
+		Recorder:    mgr.GetEventRecorderFor("project-controller"),
+		AuditLog:    auditLog,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Project")
 		os.Exit(1)
 	}
-
-	neonClient := neon.CreateClient(string(apiKey))
 	if err = (&controllers.BranchReconciler{
-		Client:     mgr.GetClient(),
-		Scheme:     mgr.GetScheme(),
-		NeonClient: neonClient,
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		NeonClients: neonClients,
+		Recorder:    mgr.GetEventRecorderFor("branch-controller"),
+		AuditLog:    auditLog,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Branch")
 		os.Exit(1)
 	}
 	if err = (&controllers.EndpointReconciler{
-		Client:     mgr.GetClient(),
-		Scheme:     mgr.GetScheme(),
-		NeonClient: neonClient,
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		NeonClients: neonClients,
+		Recorder:    mgr.GetEventRecorderFor("endpoint-controller"),
+		AuditLog:    auditLog,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Endpoint")
 		os.Exit(1)
 	}
+	if err = (&controllers.DatabaseReconciler{
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		NeonClients: neonClients,
+		Recorder:    mgr.GetEventRecorderFor("database-controller"),
+		AuditLog:    auditLog,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Database")
+		os.Exit(1)
+	}
+	if err = (&controllers.RoleReconciler{
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		NeonClients: neonClients,
+		Recorder:    mgr.GetEventRecorderFor("role-controller"),
+		AuditLog:    auditLog,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Role")
+		os.Exit(1)
+	}
+	if err = (&controllers.BranchRestoreReconciler{
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		NeonClients: neonClients,
+		Recorder:    mgr.GetEventRecorderFor("branchrestore-controller"),
+		AuditLog:    auditLog,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "BranchRestore")
+		os.Exit(1)
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -146,10 +319,132 @@ func main() {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	if neonRequireAPIKey {
+		if err := mgr.AddReadyzCheck("neon-api-key", func(_ *http.Request) error {
+			return defaultClient.CheckAPIKey()
+		}); err != nil {
+			setupLog.Error(err, "unable to set up ready check")
+			os.Exit(1)
+		}
+	}
+
+	if neonBreaker != nil {
+		if err := mgr.AddReadyzCheck("neon-api", func(_ *http.Request) error {
+			return neonBreaker.Check()
+		}); err != nil {
+			setupLog.Error(err, "unable to set up ready check")
+			os.Exit(1)
+		}
+	}
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		setupLog.Error(err, "unable to flush traces")
+	}
+}
+
+// addAPIKeySource loads the API key of client from the first configured
+// source, see controllers.APIKeySources. Keys in files and Secrets are
+// reloaded when they change.
+func addAPIKeySource(mgr ctrl.Manager, client *neon.Client, sources controllers.APIKeySources) error {
+	switch sources.Resolve() {
+	case controllers.APIKeyFromFile:
+		setupLog.Info("reading the neon api key from a file", "path", sources.File)
+	case controllers.APIKeyFromEnv:
+		setupLog.Info("reading the neon api key from the NEON_API_KEY env var")
+	default:
+		setupLog.Info("reading the neon api key from a secret", "secret", sources.Secret.String(), "key", sources.Key)
+	}
+	if loader := sources.Loader(mgr.GetCache(), client); loader != nil {
+		return mgr.Add(loader)
+	}
+	return nil
+}
+
+// openAuditLog opens the audit log at path, or returns nil if path is empty.
+// The file is kept open for the lifetime of the operator.
+func openAuditLog(path string) (*controllers.AuditLog, error) {
+	switch path {
+	case "":
+		return nil, nil
+	case "-":
+		return controllers.NewAuditLog(os.Stdout), nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return controllers.NewAuditLog(f), nil
+}
+
+// envOrDefault returns the value of the env var name, or def if it is unset
+// or empty.
+func envOrDefault(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// setupTracing installs a tracer provider exporting spans to the OTLP
+// collector at endpoint. Without an endpoint the global no-op provider is
+// kept. The returned function flushes pending spans.
+func setupTracing(endpoint string, insecure bool, sampleRatio float64) (func(context.Context) error, error) {
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporterOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpoint)}
+	if insecure {
+		exporterOpts = append(exporterOpts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(context.Background(), exporterOpts...)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceNameKey.String("neon-kube-operator"),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// neonTransport returns the transport used for Neon API requests. Proxies are
+// taken from the environment, as with http.DefaultTransport, and caFile, if
+// set, replaces the system roots.
+func neonTransport(caFile string) (http.RoundTripper, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caFile == "" {
+		return transport, nil
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	transport.TLSClientConfig = &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+	return transport, nil
 }
@@ -0,0 +1,110 @@
+package neon
+
+import (
+	"context"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	neontechv1alpha1 "github.com/evanshortiss/neon-kube-operator/api/v1alpha1"
+)
+
+// ProjectAPI manages Neon projects.
+type ProjectAPI interface {
+	CreateProject(ctx context.Context, project *neontechv1alpha1.Project) (*ProjectResponse, error)
+	GetProject(ctx context.Context, project *neontechv1alpha1.Project) (*ProjectResponse, error)
+	DeleteProject(ctx context.Context, project *neontechv1alpha1.Project) (*ProjectResponse, error)
+	FindProject(ctx context.Context, project *neontechv1alpha1.Project, owned map[string]bool) (*Project, error)
+	ListProjects(ctx context.Context) ([]Project, error)
+}
+
+// BranchAPI manages Neon branches.
+type BranchAPI interface {
+	CreateBranch(ctx context.Context, branch *neontechv1alpha1.Branch) (*BranchResponse, error)
+	GetBranch(ctx context.Context, branch *neontechv1alpha1.Branch) (*BranchResponse, error)
+	DeleteBranch(ctx context.Context, branch *neontechv1alpha1.Branch) (*BranchResponse, error)
+	FindBranch(ctx context.Context, branch *neontechv1alpha1.Branch, owned map[string]bool) (*Branch, error)
+	ListBranches(ctx context.Context, projectId string) ([]Branch, error)
+	RestoreBranch(ctx context.Context, restore *neontechv1alpha1.BranchRestore) (*BranchResponse, error)
+}
+
+// EndpointAPI manages Neon compute endpoints.
+type EndpointAPI interface {
+	CreateEndpoint(ctx context.Context, k8sClient client.Client, e *neontechv1alpha1.Endpoint) (*EndpointResponse, error)
+	GetEndpoint(ctx context.Context, k8sClient client.Client, e *neontechv1alpha1.Endpoint) (*EndpointResponse, error)
+	DeleteEndpoint(ctx context.Context, k8sClient client.Client, e *neontechv1alpha1.Endpoint) (*EndpointResponse, error)
+	FindEndpoint(ctx context.Context, k8sClient client.Client, e *neontechv1alpha1.Endpoint, owned map[string]bool) (*Endpoint, error)
+	ListEndpoints(ctx context.Context, projectId string) ([]Endpoint, error)
+}
+
+// RoleAPI manages the Postgres roles of Neon branches.
+type RoleAPI interface {
+	CreateRole(ctx context.Context, r *neontechv1alpha1.Role) (*RoleResponse, error)
+	GetRole(ctx context.Context, r *neontechv1alpha1.Role) (*RoleResponse, error)
+	DeleteRole(ctx context.Context, r *neontechv1alpha1.Role) (*RoleResponse, error)
+	ResetRolePassword(ctx context.Context, r *neontechv1alpha1.Role) (*RoleResponse, error)
+	GetRoles(ctx context.Context, projectId, branchId string) ([]Role, error)
+	GetFirstRole(ctx context.Context, projectId, branchId string) (string, error)
+	GetRolePassword(ctx context.Context, projectId, branchId, role string) (string, error)
+}
+
+// DatabaseAPI manages the Postgres databases of Neon branches.
+type DatabaseAPI interface {
+	CreateDatabase(ctx context.Context, d *neontechv1alpha1.Database) (*DatabaseResponse, error)
+	GetDatabase(ctx context.Context, d *neontechv1alpha1.Database) (*DatabaseResponse, error)
+	UpdateDatabase(ctx context.Context, d *neontechv1alpha1.Database) (*DatabaseResponse, error)
+	DeleteDatabase(ctx context.Context, d *neontechv1alpha1.Database) (*DatabaseResponse, error)
+	FindDatabase(ctx context.Context, d *neontechv1alpha1.Database) (*Database, error)
+	ListDatabases(ctx context.Context, projectId, branchId string) ([]Database, error)
+}
+
+// OperationAPI tracks Neon operations.
+type OperationAPI interface {
+	GetOperation(ctx context.Context, projectId, operationId string) (*Operation, error)
+	PendingOperations(ctx context.Context, projectId string, operationIds []string) ([]string, error)
+}
+
+// ProjectClient is what the project reconciler needs from Neon.
+type ProjectClient interface {
+	ProjectAPI
+	BranchAPI
+	EndpointAPI
+	OperationAPI
+}
+
+// BranchClient is what the branch reconciler needs from Neon.
+type BranchClient interface {
+	BranchAPI
+	OperationAPI
+}
+
+// EndpointClient is what the endpoint reconciler needs from Neon.
+type EndpointClient interface {
+	EndpointAPI
+	RoleAPI
+	OperationAPI
+}
+
+// DatabaseClient is what the database reconciler needs from Neon.
+type DatabaseClient interface {
+	DatabaseAPI
+	OperationAPI
+}
+
+// RoleClient is what the role reconciler needs from Neon.
+type RoleClient interface {
+	RoleAPI
+	OperationAPI
+}
+
+// API is the full set of Neon operations implemented by Client.
+type API interface {
+	ProjectAPI
+	BranchAPI
+	EndpointAPI
+	RoleAPI
+	DatabaseAPI
+	OperationAPI
+	ListOperations(ctx context.Context, projectId string) ([]Operation, error)
+}
+
+var _ API = (*Client)(nil)
@@ -0,0 +1,142 @@
+package neon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/evanshortiss/neon-kube-operator/neon/redact"
+)
+
+// AuditEvent describes a call to the Neon API that changes a resource or
+// reveals a secret, such as creating a branch or revealing a role password.
+type AuditEvent struct {
+	Time time.Time `json:"time"`
+	// Action is "create", "update", "delete", "reveal_password", or the
+	// last path segment of other actions such as "reset_password".
+	Action     string `json:"action"`
+	Method     string `json:"method"`
+	Route      string `json:"route"`
+	ProjectId  string `json:"project_id,omitempty"`
+	BranchId   string `json:"branch_id,omitempty"`
+	EndpointId string `json:"endpoint_id,omitempty"`
+	// OperationIds are the operations Neon started for the call.
+	OperationIds []string `json:"operation_ids,omitempty"`
+	// StatusCode is zero if no response was received.
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Succeeded reports whether Neon accepted the call.
+func (e *AuditEvent) Succeeded() bool {
+	return e.Error == "" && e.StatusCode > 0 && e.StatusCode < 400
+}
+
+// Auditor receives the audit events of the calls made with a context
+// returned by ContextWithAuditor.
+type Auditor interface {
+	Audit(ctx context.Context, event AuditEvent)
+}
+
+type auditorKey struct{}
+
+// ContextWithAuditor returns a context whose Neon API calls are reported to
+// auditor, typically one that knows the resource being reconciled.
+func ContextWithAuditor(ctx context.Context, auditor Auditor) context.Context {
+	return context.WithValue(ctx, auditorKey{}, auditor)
+}
+
+// auditAction returns the audit action of a request, or "" for requests
+// that are not audited.
+func auditAction(method, template string) string {
+	last := template[strings.LastIndex(template, "/")+1:]
+	switch method {
+	case http.MethodGet:
+		if last == "reveal_password" {
+			return last
+		}
+		return ""
+	case http.MethodPost:
+		if _, ok := routeParams[last]; ok {
+			return "create"
+		}
+		return last
+	case http.MethodPatch:
+		return "update"
+	case http.MethodDelete:
+		return "delete"
+	}
+	return ""
+}
+
+// auditTransport reports audited calls to the Auditor of the request
+// context once all retries are done. Operation ids are read from the
+// response, which is otherwise passed on untouched. Bodies of revealed
+// passwords are never read.
+type auditTransport struct {
+	next http.RoundTripper
+}
+
+func (t *auditTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	auditor, _ := req.Context().Value(auditorKey{}).(Auditor)
+	r := parseRoute(req.URL.Path)
+	action := auditAction(req.Method, r.template)
+	if auditor == nil || action == "" {
+		return t.next.RoundTrip(req)
+	}
+
+	event := AuditEvent{
+		Time:       time.Now(),
+		Action:     action,
+		Method:     req.Method,
+		Route:      r.template,
+		ProjectId:  r.projectId,
+		BranchId:   r.branchId,
+		EndpointId: r.endpointId,
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		event.Error = redact.String(err.Error())
+		auditor.Audit(req.Context(), event)
+		return nil, err
+	}
+
+	event.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 400 {
+		event.Error = http.StatusText(resp.StatusCode)
+	} else if req.Method != http.MethodGet {
+		data, readErr := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+		if readErr == nil {
+			event.addOperations(data)
+		}
+	}
+	auditor.Audit(req.Context(), event)
+	return resp, nil
+}
+
+// addOperations adds the operations listed in a response body to the
+// event, along with the ids of the branch and endpoint they act on if the
+// route did not name them.
+func (e *AuditEvent) addOperations(body []byte) {
+	var out struct {
+		Operations []Operation `json:"operations"`
+	}
+	if json.Unmarshal(body, &out) != nil {
+		return
+	}
+	for _, op := range out.Operations {
+		e.OperationIds = append(e.OperationIds, op.Id)
+		if e.BranchId == "" {
+			e.BranchId = op.BranchId
+		}
+		if e.EndpointId == "" {
+			e.EndpointId = op.EndpointId
+		}
+	}
+}
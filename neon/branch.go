@@ -1,14 +1,15 @@
 package neon
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
 	neontechv1alpha1 "github.com/evanshortiss/neon-kube-operator/api/v1alpha1"
 )
 
@@ -18,8 +19,8 @@ func branchSpecToCreateRequestBody(b *neontechv1alpha1.Branch) map[string]any {
 	branch := make(map[string]any)
 	branch["name"] = b.Name
 
-	if branchSpec.ParentId != nil {
-		branch["parent_id"] = branchSpec.ParentId
+	if parentId := b.NeonParentId(); parentId != "" {
+		branch["parent_id"] = parentId
 	}
 
 	if branchSpec.ParentStartPoint != nil {
@@ -37,94 +38,61 @@ func branchSpecToCreateRequestBody(b *neontechv1alpha1.Branch) map[string]any {
 	return body
 }
 
-func (c *Client) CreateBranch(ctx context.Context, branch *neontechv1alpha1.Branch) (map[string]any, error) {
-	url := fmt.Sprintf("https://console.neon.tech/api/v2/projects/%s/branches", branch.Spec.ProjectId)
-
-	reqData, err := json.Marshal(branchSpecToCreateRequestBody(branch))
-	if err != nil {
-		return nil, err
-	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(reqData))
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Add("Authorization", "Bearer "+c.apiKey)
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Content-Type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
+func (c *Client) CreateBranch(ctx context.Context, branch *neontechv1alpha1.Branch) (*BranchResponse, error) {
+	path := fmt.Sprintf("/projects/%s/branches", branch.NeonProjectId())
+	resp, err := c.do(ctx, http.MethodPost, path, branchSpecToCreateRequestBody(branch))
 	if err != nil {
 		return nil, err
 	}
 
 	defer resp.Body.Close()
-	if resp.StatusCode != 201 { // TODO: add already exists
-		return nil, fmt.Errorf("failed to create branch: %s", resp.Status)
+	if resp.StatusCode != 201 {
+		return nil, fmt.Errorf("failed to create branch: %w", newAPIError(resp))
 	}
 
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
+	var out BranchResponse
+	if err := decodeResponse(resp, &out); err != nil {
 		return nil, err
 	}
 
-	m := make(map[string]any)
-	err = json.Unmarshal(data, &m)
-	if err != nil {
-		return nil, err
-	}
-
-	return m, nil
+	return &out, nil
 }
 
-func (c *Client) DeleteBranch(ctx context.Context, branch *neontechv1alpha1.Branch) (map[string]any, error) {
-	url := fmt.Sprintf("https://console.neon.tech/api/v2/projects/%s/branches/%s", branch.Spec.ProjectId, branch.Status.Id)
-	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, url, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Add("Authorization", "Bearer "+c.apiKey)
-	req.Header.Add("Accept", "application/json")
-	resp, err := http.DefaultClient.Do(req)
+// DeleteBranch deletes the branch. It returns a nil response if the branch
+// no longer exists in Neon.
+func (c *Client) DeleteBranch(ctx context.Context, branch *neontechv1alpha1.Branch) (*BranchResponse, error) {
+	path := fmt.Sprintf("/projects/%s/branches/%s", branch.NeonProjectId(), branch.Status.Id)
+	resp, err := c.do(ctx, http.MethodDelete, path, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	defer resp.Body.Close()
-	if resp.StatusCode != 200 && resp.StatusCode != 404 {
-		return nil, fmt.Errorf("failed to create branch: %s", resp.Status)
+	if resp.StatusCode == 404 {
+		return nil, nil
 	}
-
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("failed to delete branch: %w", newAPIError(resp))
 	}
 
-	m := make(map[string]any)
-	err = json.Unmarshal(data, &m)
-	if err != nil {
+	var out BranchResponse
+	if err := decodeResponse(resp, &out); err != nil {
 		return nil, err
 	}
 
-	return m, nil
+	return &out, nil
 }
 
 var (
 	ErrBranchNotFound = errors.New("branch not found")
 )
 
-func (c *Client) GetBranch(ctx context.Context, branch *neontechv1alpha1.Branch) (map[string]any, error) {
+func (c *Client) GetBranch(ctx context.Context, branch *neontechv1alpha1.Branch) (*BranchResponse, error) {
 	if branch.Status.Id == "" {
 		return nil, ErrBranchNotFound
 	}
-	url := fmt.Sprintf("https://console.neon.tech/api/v2/projects/%s/branches/%s", branch.Spec.ProjectId, branch.Status.Id)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Add("Authorization", "Bearer "+c.apiKey)
-	req.Header.Add("Accept", "application/json")
-	resp, err := http.DefaultClient.Do(req)
+	path := fmt.Sprintf("/projects/%s/branches/%s", branch.NeonProjectId(), branch.Status.Id)
+	resp, err := c.do(ctx, http.MethodGet, path, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -133,48 +101,124 @@ func (c *Client) GetBranch(ctx context.Context, branch *neontechv1alpha1.Branch)
 		if resp.StatusCode == 404 {
 			return nil, ErrBranchNotFound
 		}
-		return nil, fmt.Errorf("failed to get branch %s", resp.Status)
+		return nil, fmt.Errorf("failed to get branch: %w", newAPIError(resp))
 	}
-	m := make(map[string]any)
-	bytes, err := io.ReadAll(resp.Body)
-	if err != nil {
+	var out BranchResponse
+	if err := decodeResponse(resp, &out); err != nil {
 		return nil, err
 	}
-	err = json.Unmarshal(bytes, &m)
-	if err != nil {
+	return &out, nil
+}
+
+// FindBranch returns the branch in the project named after the Branch
+// resource, or ErrBranchNotFound if there is none. Branches whose ids are
+// in owned belong to other resources and are skipped, as are the primary and
+// default branches of the project, and an error is returned if several
+// branches match.
+func (c *Client) FindBranch(ctx context.Context, branch *neontechv1alpha1.Branch, owned map[string]bool) (*Branch, error) {
+	var found []Branch
+	it := c.Branches(branch.NeonProjectId())
+	for it.Next(ctx) {
+		if b := it.Item(); adoptable(b, branch, owned) {
+			found = append(found, b)
+		}
+	}
+	if err := it.Err(); err != nil {
 		return nil, err
 	}
-	return m, nil
+	return oneBranch(found, branch)
+}
+
+// adoptable reports whether b can be adopted by branch. The primary and
+// default branches hold the main data of a project and are never adopted.
+func adoptable(b Branch, branch *neontechv1alpha1.Branch, owned map[string]bool) bool {
+	return b.Name == branch.Name && !owned[b.Id] && !b.Primary && !b.Default
+}
+
+// oneBranch returns the only branch found for adoption.
+func oneBranch(found []Branch, branch *neontechv1alpha1.Branch) (*Branch, error) {
+	switch len(found) {
+	case 0:
+		return nil, ErrBranchNotFound
+	case 1:
+		return &found[0], nil
+	}
+	return nil, fmt.Errorf("found %d branches named %s in project %s, cannot choose one to adopt", len(found), branch.Name, branch.NeonProjectId())
 }
 
-func NewBranchStatus(response map[string]any) neontechv1alpha1.BranchStatus {
-	var branchStatus neontechv1alpha1.BranchStatus
-	if branch, ok := response["branch"].(map[string]any); ok {
-		branchStatus.Id, _ = branch["id"].(string)
-		branchStatus.Name, _ = branch["name"].(string)
-		branchStatus.ProjectId, _ = branch["project_id"].(string)
-		branchStatus.ParentId, _ = branch["parent_id"].(string)
-		branchStatus.ParentLsn, _ = branch["parent_lsn"].(string)
-		branchStatus.Primary, _ = branch["primary"].(bool)
-		branchStatus.CreatedAt, _ = branch["created_at"].(string)
-		branchStatus.UpdatedAt, _ = branch["updated_at"].(string)
+// RestoreBranch restores the branch recorded in the status of the restore
+// from its source branch, at the point in time set in the spec if any.
+func (c *Client) RestoreBranch(ctx context.Context, restore *neontechv1alpha1.BranchRestore) (*BranchResponse, error) {
+	source := restore.Spec.Source
+	body := map[string]any{"source_branch_id": restore.Status.SourceBranchId}
+	if source.Lsn != nil {
+		body["source_lsn"] = source.Lsn
+	}
+	if source.Timestamp != nil {
+		body["source_timestamp"] = source.Timestamp
+	}
+	if restore.Spec.PreserveUnderName != "" {
+		body["preserve_under_name"] = restore.Spec.PreserveUnderName
+	}
+
+	path := fmt.Sprintf("/projects/%s/branches/%s/restore", restore.Status.ProjectId, restore.Status.BranchId)
+	resp, err := c.do(ctx, http.MethodPost, path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("failed to restore branch: %w", newAPIError(resp))
+	}
+
+	var out BranchResponse
+	if err := decodeResponse(resp, &out); err != nil {
+		return nil, err
 	}
 
-	return branchStatus
+	return &out, nil
 }
 
-func NewEndpointStatus(response map[string]any) neontechv1alpha1.EndpointStatus {
-	var es neontechv1alpha1.EndpointStatus
-	if branch, ok := response["endpoint"].(map[string]any); ok {
-		es.Id, _ = branch["id"].(string)
-		es.CurrentState, _ = branch["current_state"].(string)
-		es.PendingState, _ = branch["pending_state"].(string)
-		es.Host, _ = branch["host"].(string)
-		es.CreatedAt, _ = branch["created_at"].(string)
-		es.UpdatedAt, _ = branch["updated_at"].(string)
-		es.ProjectId, _ = branch["project_id"].(string)
-		es.BranchId, _ = branch["branch_id"].(string)
+// GetBranchRef returns the Branch resource named ref in namespace. It
+// returns an error wrapping ErrRetryAgain until the branch exists in Neon.
+func GetBranchRef(ctx context.Context, k8sClient client.Client, namespace, ref string) (*neontechv1alpha1.Branch, error) {
+	branch := &neontechv1alpha1.Branch{}
+	err := k8sClient.Get(ctx, types.NamespacedName{Name: ref, Namespace: namespace}, branch)
+	if kerrors.IsNotFound(err) {
+		return nil, fmt.Errorf("branch is not found yet, %w", ErrRetryAgain)
 	}
+	if err != nil {
+		return nil, err
+	}
+	if !branch.Status.State.Exists() {
+		return nil, fmt.Errorf("branch status is not updated yet, %w", ErrRetryAgain)
+	}
+	return branch, nil
+}
 
-	return es
+func NewBranchStatus(branch Branch) neontechv1alpha1.BranchStatus {
+	return neontechv1alpha1.BranchStatus{
+		Id:        branch.Id,
+		Name:      branch.Name,
+		ProjectId: branch.ProjectId,
+		ParentId:  branch.ParentId,
+		ParentLsn: branch.ParentLsn,
+		Primary:   branch.Primary,
+		CreatedAt: branch.CreatedAt,
+		UpdatedAt: branch.UpdatedAt,
+	}
+}
+
+func NewEndpointStatus(endpoint Endpoint) neontechv1alpha1.EndpointStatus {
+	return neontechv1alpha1.EndpointStatus{
+		Id:           endpoint.Id,
+		CurrentState: endpoint.CurrentState,
+		PendingState: endpoint.PendingState,
+		Host:         endpoint.Host,
+		CreatedAt:    endpoint.CreatedAt,
+		UpdatedAt:    endpoint.UpdatedAt,
+		ProjectId:    endpoint.ProjectId,
+		BranchId:     endpoint.BranchId,
+	}
 }
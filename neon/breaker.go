@@ -0,0 +1,164 @@
+package neon
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// ErrCircuitOpen is returned for calls refused by the circuit breaker. It
+// also matches ErrRetryAgain, so reconciles are requeued until the breaker
+// closes.
+var ErrCircuitOpen error = circuitOpenError{}
+
+type circuitOpenError struct{}
+
+func (circuitOpenError) Error() string {
+	return "the Neon API is unavailable, calls are paused by the circuit breaker"
+}
+
+func (circuitOpenError) Is(target error) bool {
+	return target == ErrRetryAgain
+}
+
+var circuitBreakerOpen = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "neon",
+	Subsystem: "api",
+	Name:      "circuit_breaker_open",
+	Help:      "Whether calls to the Neon API are paused because of repeated failures.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(circuitBreakerOpen)
+}
+
+// CircuitBreaker stops calls to the Neon API after repeated failures, so
+// that an outage is not made worse by every reconcile retrying. A call
+// fails if no response is received or Neon answers with a 5xx status once
+// all retries are done.
+//
+// After a threshold of consecutive failures the breaker opens and calls
+// fail with ErrCircuitOpen. Once the open duration has passed a single call
+// is let through to probe for recovery: the breaker closes if it succeeds
+// and stays open for another open duration otherwise.
+//
+// A breaker can be shared by several clients, since an outage affects all
+// Neon accounts alike.
+type CircuitBreaker struct {
+	threshold    int
+	openDuration time.Duration
+
+	mu       sync.Mutex
+	failures int
+	open     bool
+	openedAt time.Time
+	probing  bool
+}
+
+// NewCircuitBreaker returns a closed breaker opening after threshold
+// consecutive failures.
+func NewCircuitBreaker(threshold int, openDuration time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, openDuration: openDuration}
+}
+
+// WithCircuitBreaker makes the client's calls go through breaker. Clients
+// have no breaker unless this option is set.
+func WithCircuitBreaker(breaker *CircuitBreaker) ClientOption {
+	return func(c *Client) {
+		c.breaker = breaker
+	}
+}
+
+// Check returns ErrCircuitOpen while the breaker is open.
+func (b *CircuitBreaker) Check() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.open {
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// allow reports whether a call may be made, and whether it is the probe
+// of an open breaker.
+func (b *CircuitBreaker) allow() (ok, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case !b.open:
+		return true, false
+	case b.probing || time.Since(b.openedAt) < b.openDuration:
+		return false, false
+	}
+	b.probing = true
+	return true, true
+}
+
+type callOutcome int
+
+const (
+	callSucceeded callOutcome = iota
+	callFailed
+	// callAbandoned is a call cancelled by its caller, which says nothing
+	// about the health of the API.
+	callAbandoned
+)
+
+func (b *CircuitBreaker) record(outcome callOutcome, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probing = false
+	}
+	switch outcome {
+	case callSucceeded:
+		b.failures = 0
+		b.setOpen(false)
+	case callFailed:
+		b.failures++
+		if probe || (!b.open && b.failures >= b.threshold) {
+			b.openedAt = time.Now()
+			b.setOpen(true)
+		}
+	}
+}
+
+func (b *CircuitBreaker) setOpen(open bool) {
+	b.open = open
+	if open {
+		circuitBreakerOpen.Set(1)
+	} else {
+		circuitBreakerOpen.Set(0)
+	}
+}
+
+// breakerTransport refuses calls while the breaker is open, and reports the
+// outcome of the others to it.
+type breakerTransport struct {
+	next    http.RoundTripper
+	breaker *CircuitBreaker
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ok, probe := t.breaker.allow()
+	if !ok {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, ErrCircuitOpen
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	switch {
+	case err != nil && req.Context().Err() != nil:
+		t.breaker.record(callAbandoned, probe)
+	case err != nil || resp.StatusCode >= 500:
+		t.breaker.record(callFailed, probe)
+	default:
+		t.breaker.record(callSucceeded, probe)
+	}
+	return resp, err
+}
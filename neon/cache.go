@@ -0,0 +1,443 @@
+package neon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neontechv1alpha1 "github.com/evanshortiss/neon-kube-operator/api/v1alpha1"
+)
+
+// Cache serves branch, endpoint and role reads from a per-project snapshot
+// instead of calling the Neon API for every object. A project is listed when
+// it is first read, again once its snapshot is older than the resync period,
+// and after any write made through the cache. All other calls are passed to
+// the wrapped API.
+//
+// Start refreshes known projects in the background so that reads rarely
+// wait on a list. It is meant to be added to the controller manager.
+type Cache struct {
+	API
+
+	resync time.Duration
+
+	mu       sync.Mutex
+	projects map[string]*projectCache
+}
+
+var _ API = (*Cache)(nil)
+
+// projectCache is the snapshot of one project.
+type projectCache struct {
+	// syncMu serializes lists of the project.
+	syncMu sync.Mutex
+
+	mu         sync.RWMutex
+	syncedAt   time.Time
+	listed     bool
+	generation int
+	branches   []Branch
+	endpoints  []Endpoint
+	// roles and passwords are filled in on demand and dropped with every
+	// sync. passwords is keyed by branch id and role name.
+	roles     map[string][]Role
+	passwords map[string]string
+}
+
+// NewCache returns a Cache over api that lists each project at most once
+// per resync period, unless the cache made a write to it.
+func NewCache(api API, resync time.Duration) *Cache {
+	return &Cache{
+		API:      api,
+		resync:   resync,
+		projects: make(map[string]*projectCache),
+	}
+}
+
+// Close closes the cached API if it can be closed.
+func (c *Cache) Close() {
+	if closer, ok := c.API.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// Start refreshes the snapshots of known projects every resync period until
+// ctx is done.
+func (c *Cache) Start(ctx context.Context) error {
+	if c.resync <= 0 {
+		<-ctx.Done()
+		return nil
+	}
+	logger := log.FromContext(ctx).WithName("neon-cache")
+	ticker := time.NewTicker(c.resync)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		c.mu.Lock()
+		projects := make(map[string]*projectCache, len(c.projects))
+		for id, p := range c.projects {
+			projects[id] = p
+		}
+		c.mu.Unlock()
+		for id, p := range projects {
+			err := c.sync(ctx, id, p, true)
+			switch {
+			case IsNotFound(err):
+				// The project was deleted outside the cache.
+				logger.Info("dropping deleted Neon project from the cache", "project", id)
+				c.forget(id, p)
+			case err != nil:
+				logger.Error(err, "failed to refresh Neon project", "project", id)
+			}
+		}
+	}
+}
+
+// Invalidate makes the next read of the project list it again.
+func (c *Cache) Invalidate(projectId string) {
+	c.mu.Lock()
+	p, ok := c.projects[projectId]
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.generation++
+	p.syncedAt = time.Time{}
+}
+
+// project returns the snapshot of the project, listing it if it is missing
+// or stale. A project whose first list fails is not kept, so that a
+// mistyped or deleted project is not refreshed in the background.
+func (c *Cache) project(ctx context.Context, projectId string) (*projectCache, error) {
+	c.mu.Lock()
+	p, ok := c.projects[projectId]
+	if !ok {
+		p = &projectCache{}
+		c.projects[projectId] = p
+	}
+	c.mu.Unlock()
+
+	if err := c.sync(ctx, projectId, p, false); err != nil {
+		p.mu.RLock()
+		listed := p.listed
+		p.mu.RUnlock()
+		if !listed || IsNotFound(err) {
+			c.forget(projectId, p)
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// forget drops the snapshot p of the project, unless it has been replaced.
+func (c *Cache) forget(projectId string, p *projectCache) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.projects[projectId] == p {
+		delete(c.projects, projectId)
+	}
+}
+
+func (p *projectCache) fresh(resync time.Duration) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return !p.syncedAt.IsZero() && time.Since(p.syncedAt) < resync
+}
+
+// sync lists the project unless it is fresh. force lists it regardless.
+func (c *Cache) sync(ctx context.Context, projectId string, p *projectCache, force bool) error {
+	p.syncMu.Lock()
+	defer p.syncMu.Unlock()
+	if !force && p.fresh(c.resync) {
+		return nil
+	}
+
+	p.mu.RLock()
+	generation := p.generation
+	p.mu.RUnlock()
+
+	branches, err := c.API.ListBranches(ctx, projectId)
+	if err != nil {
+		return err
+	}
+	endpoints, err := c.API.ListEndpoints(ctx, projectId)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.branches, p.endpoints = branches, endpoints
+	p.roles, p.passwords = nil, nil
+	p.listed = true
+	// A write made while listing may be missing from the lists, so the
+	// snapshot is only marked fresh if there was none.
+	if p.generation == generation {
+		p.syncedAt = time.Now()
+	}
+	return nil
+}
+
+// GetBranch returns the branch from the snapshot of its project. A branch
+// missing from the snapshot may have been created since it was listed, so
+// Neon is asked before reporting ErrBranchNotFound.
+func (c *Cache) GetBranch(ctx context.Context, branch *neontechv1alpha1.Branch) (*BranchResponse, error) {
+	if branch.Status.Id == "" {
+		return nil, ErrBranchNotFound
+	}
+	resp, err := c.findBranch(ctx, branch.NeonProjectId(), func(b *Branch) bool {
+		return b.Id == branch.Status.Id
+	})
+	if errors.Is(err, ErrBranchNotFound) {
+		c.Invalidate(branch.NeonProjectId())
+		return c.API.GetBranch(ctx, branch)
+	}
+	return resp, err
+}
+
+func (c *Cache) FindBranch(ctx context.Context, branch *neontechv1alpha1.Branch, owned map[string]bool) (*Branch, error) {
+	p, err := c.project(ctx, branch.NeonProjectId())
+	if err != nil {
+		return nil, err
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var found []Branch
+	for _, b := range p.branches {
+		if adoptable(b, branch, owned) {
+			found = append(found, b)
+		}
+	}
+	return oneBranch(found, branch)
+}
+
+func (c *Cache) findBranch(ctx context.Context, projectId string, match func(*Branch) bool) (*BranchResponse, error) {
+	p, err := c.project(ctx, projectId)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for i := range p.branches {
+		if match(&p.branches[i]) {
+			return &BranchResponse{Branch: p.branches[i]}, nil
+		}
+	}
+	return nil, ErrBranchNotFound
+}
+
+// DeleteProject drops the snapshot of the project once it is deleted, so
+// that it is no longer refreshed.
+func (c *Cache) DeleteProject(ctx context.Context, project *neontechv1alpha1.Project) (*ProjectResponse, error) {
+	resp, err := c.API.DeleteProject(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+	c.Invalidate(project.Status.Id)
+	c.mu.Lock()
+	delete(c.projects, project.Status.Id)
+	c.mu.Unlock()
+	return resp, nil
+}
+
+func (c *Cache) CreateBranch(ctx context.Context, branch *neontechv1alpha1.Branch) (*BranchResponse, error) {
+	defer c.Invalidate(branch.NeonProjectId())
+	return c.API.CreateBranch(ctx, branch)
+}
+
+func (c *Cache) DeleteBranch(ctx context.Context, branch *neontechv1alpha1.Branch) (*BranchResponse, error) {
+	defer c.Invalidate(branch.NeonProjectId())
+	return c.API.DeleteBranch(ctx, branch)
+}
+
+func (c *Cache) RestoreBranch(ctx context.Context, restore *neontechv1alpha1.BranchRestore) (*BranchResponse, error) {
+	defer c.Invalidate(restore.Status.ProjectId)
+	return c.API.RestoreBranch(ctx, restore)
+}
+
+func (c *Cache) ListBranches(ctx context.Context, projectId string) ([]Branch, error) {
+	p, err := c.project(ctx, projectId)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]Branch(nil), p.branches...), nil
+}
+
+// GetEndpoint returns the endpoint from the snapshot of its project. An
+// endpoint missing from the snapshot may have been created since it was
+// listed, so Neon is asked before reporting ErrEndpointNotFound.
+func (c *Cache) GetEndpoint(ctx context.Context, k8sClient client.Client, e *neontechv1alpha1.Endpoint) (*EndpointResponse, error) {
+	if e.Status.Id == "" {
+		return nil, ErrEndpointNotFound
+	}
+	_, projectId, err := GetBranchProjectId(ctx, k8sClient, e)
+	if err != nil {
+		return nil, err
+	}
+	p, err := c.project(ctx, projectId)
+	if err != nil {
+		return nil, err
+	}
+	if ep, ok := p.endpoint(e.Status.Id); ok {
+		return &EndpointResponse{Endpoint: ep}, nil
+	}
+	c.Invalidate(projectId)
+	return c.API.GetEndpoint(ctx, k8sClient, e)
+}
+
+// endpoint returns the endpoint with the id from the snapshot.
+func (p *projectCache) endpoint(id string) (Endpoint, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, ep := range p.endpoints {
+		if ep.Id == id {
+			return ep, true
+		}
+	}
+	return Endpoint{}, false
+}
+
+func (c *Cache) FindEndpoint(ctx context.Context, k8sClient client.Client, e *neontechv1alpha1.Endpoint, owned map[string]bool) (*Endpoint, error) {
+	branchId, projectId, err := GetBranchProjectId(ctx, k8sClient, e)
+	if err != nil {
+		return nil, err
+	}
+	p, err := c.project(ctx, projectId)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var found []Endpoint
+	for _, ep := range p.endpoints {
+		if ep.BranchId == branchId && ep.Type == e.Spec.Type && !owned[ep.Id] {
+			found = append(found, ep)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, ErrEndpointNotFound
+	case 1:
+		return &found[0], nil
+	}
+	return nil, fmt.Errorf("found %d %s endpoints on branch %s, cannot choose one to adopt", len(found), e.Spec.Type, branchId)
+}
+
+func (c *Cache) CreateEndpoint(ctx context.Context, k8sClient client.Client, e *neontechv1alpha1.Endpoint) (*EndpointResponse, error) {
+	_, projectId, err := GetBranchProjectId(ctx, k8sClient, e)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Invalidate(projectId)
+	return c.API.CreateEndpoint(ctx, k8sClient, e)
+}
+
+func (c *Cache) DeleteEndpoint(ctx context.Context, k8sClient client.Client, e *neontechv1alpha1.Endpoint) (*EndpointResponse, error) {
+	defer c.Invalidate(e.Status.ProjectId)
+	return c.API.DeleteEndpoint(ctx, k8sClient, e)
+}
+
+func (c *Cache) ListEndpoints(ctx context.Context, projectId string) ([]Endpoint, error) {
+	p, err := c.project(ctx, projectId)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]Endpoint(nil), p.endpoints...), nil
+}
+
+func (c *Cache) GetRoles(ctx context.Context, projectId, branchId string) ([]Role, error) {
+	if branchId == "" {
+		return nil, ErrBranchNotFound
+	}
+	p, err := c.project(ctx, projectId)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.RLock()
+	roles, ok := p.roles[branchId]
+	p.mu.RUnlock()
+	if ok {
+		return roles, nil
+	}
+
+	roles, err = c.API.GetRoles(ctx, projectId, branchId)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.roles == nil {
+		p.roles = make(map[string][]Role)
+	}
+	p.roles[branchId] = roles
+	return roles, nil
+}
+
+func (c *Cache) GetFirstRole(ctx context.Context, projectId, branchId string) (string, error) {
+	roles, err := c.GetRoles(ctx, projectId, branchId)
+	if err != nil {
+		return "", err
+	}
+	if len(roles) == 0 {
+		return "", errors.New("no role found")
+	}
+	return roles[0].Name, nil
+}
+
+func (c *Cache) GetRolePassword(ctx context.Context, projectId, branchId, role string) (string, error) {
+	p, err := c.project(ctx, projectId)
+	if err != nil {
+		return "", err
+	}
+	key := branchId + "/" + role
+	p.mu.RLock()
+	password, ok := p.passwords[key]
+	p.mu.RUnlock()
+	if ok {
+		return password, nil
+	}
+
+	password, err = c.API.GetRolePassword(ctx, projectId, branchId, role)
+	if err != nil {
+		return "", err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.passwords == nil {
+		p.passwords = make(map[string]string)
+	}
+	p.passwords[key] = password
+	return password, nil
+}
+
+func (c *Cache) CreateRole(ctx context.Context, r *neontechv1alpha1.Role) (*RoleResponse, error) {
+	defer c.Invalidate(r.Status.ProjectId)
+	return c.API.CreateRole(ctx, r)
+}
+
+func (c *Cache) DeleteRole(ctx context.Context, r *neontechv1alpha1.Role) (*RoleResponse, error) {
+	defer c.Invalidate(r.Status.ProjectId)
+	return c.API.DeleteRole(ctx, r)
+}
+
+// ResetRolePassword drops the snapshot of the project, so that the old
+// password is no longer served.
+func (c *Cache) ResetRolePassword(ctx context.Context, r *neontechv1alpha1.Role) (*RoleResponse, error) {
+	defer c.Invalidate(r.Status.ProjectId)
+	return c.API.ResetRolePassword(ctx, r)
+}
@@ -0,0 +1,317 @@
+// Package cassette records Neon API interactions to a file and replays them,
+// so that client tests can run against real API responses without network
+// access or credentials.
+//
+// Recordings are scrubbed before they are written: the Authorization header
+// is replaced and every "password" field and connection string password in
+// request and response bodies is masked. Values such as a project id that
+// differ between the account used for recording and the tests can be
+// replaced with placeholders using Recorder.Substitute.
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/evanshortiss/neon-kube-operator/neon/redact"
+)
+
+// Mode selects whether a Recorder talks to the real API.
+type Mode int
+
+const (
+	// ModeReplay answers requests from the cassette file and fails requests
+	// that were not recorded.
+	ModeReplay Mode = iota
+	// ModeRecord sends requests to the real API and writes the interactions
+	// to the cassette file when the Recorder is stopped.
+	ModeRecord
+)
+
+// ModeEnv is the environment variable read by ModeFromEnv.
+const ModeEnv = "NEON_CASSETTE_MODE"
+
+// ModeFromEnv returns ModeRecord if NEON_CASSETTE_MODE is "record", and
+// ModeReplay otherwise.
+func ModeFromEnv() Mode {
+	if os.Getenv(ModeEnv) == "record" {
+		return ModeRecord
+	}
+	return ModeReplay
+}
+
+// redacted replaces scrubbed values.
+const redacted = redact.Mask
+
+var (
+	scrubbedHeaders = []string{"Authorization", "Cookie", "Set-Cookie"}
+)
+
+// Request is a recorded request. JSON bodies are kept in Body so that
+// cassettes stay readable, any other body is kept in RawBody.
+type Request struct {
+	Method  string          `json:"method"`
+	URL     string          `json:"url"`
+	Header  http.Header     `json:"header,omitempty"`
+	Body    json.RawMessage `json:"body,omitempty"`
+	RawBody string          `json:"rawBody,omitempty"`
+}
+
+// Response is a recorded response, with bodies stored like in Request.
+type Response struct {
+	StatusCode int             `json:"statusCode"`
+	Header     http.Header     `json:"header,omitempty"`
+	Body       json.RawMessage `json:"body,omitempty"`
+	RawBody    string          `json:"rawBody,omitempty"`
+}
+
+func (r *Response) body() []byte {
+	if len(r.Body) > 0 {
+		return r.Body
+	}
+	return []byte(r.RawBody)
+}
+
+// Interaction is a request and the response it received.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Cassette is the content of a cassette file.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Recorder is an http.RoundTripper that records or replays interactions.
+type Recorder struct {
+	path string
+	mode Mode
+	next http.RoundTripper
+
+	mu            sync.Mutex
+	cassette      Cassette
+	used          []bool
+	substitutions []string
+}
+
+// New returns a Recorder for the cassette at path. In ModeReplay the file
+// must exist; in ModeRecord requests are sent with next, or
+// http.DefaultTransport if next is nil.
+func New(path string, mode Mode, next http.RoundTripper) (*Recorder, error) {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	r := &Recorder{path: path, mode: mode, next: next}
+	if mode == ModeReplay {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &r.cassette); err != nil {
+			return nil, fmt.Errorf("failed to read cassette %s: %w", path, err)
+		}
+		r.used = make([]bool, len(r.cassette.Interactions))
+	}
+	return r, nil
+}
+
+// Mode returns the mode of the recorder.
+func (r *Recorder) Mode() Mode {
+	return r.mode
+}
+
+// Substitute replaces value with placeholder in the URLs and bodies of
+// recorded interactions. It has no effect when replaying, where requests are
+// expected to use the placeholder already.
+func (r *Recorder) Substitute(value, placeholder string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if value != "" {
+		r.substitutions = append(r.substitutions, value, placeholder)
+	}
+}
+
+func (r *Recorder) substitute(s string) string {
+	if len(r.substitutions) == 0 {
+		return s
+	}
+	return strings.NewReplacer(r.substitutions...).Replace(s)
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		if reqBody, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+
+	if r.mode == ModeReplay {
+		return r.replay(req, reqBody)
+	}
+
+	out := req.Clone(req.Context())
+	out.Body = io.NopCloser(bytes.NewReader(reqBody))
+	resp, err := r.next.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	in := Interaction{
+		Request: Request{
+			Method: req.Method,
+			URL:    r.substitute(req.URL.String()),
+			Header: scrubHeader(req.Header),
+		},
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Header:     scrubHeader(resp.Header),
+		},
+	}
+	in.Request.Body, in.Request.RawBody = scrubBody(r.substitute(string(reqBody)))
+	in.Response.Body, in.Response.RawBody = scrubBody(r.substitute(string(respBody)))
+	r.cassette.Interactions = append(r.cassette.Interactions, in)
+	return resp, nil
+}
+
+// replay returns the response of the first unused interaction with the
+// method and URL of req. The request body must match the recorded one.
+func (r *Recorder) replay(req *http.Request, reqBody []byte) (*http.Response, error) {
+	url := req.URL.String()
+	body, rawBody := scrubBody(string(reqBody))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, in := range r.cassette.Interactions {
+		if r.used[i] || in.Request.Method != req.Method || in.Request.URL != url {
+			continue
+		}
+		if !jsonEqual(in.Request.Body, body) || in.Request.RawBody != rawBody {
+			return nil, fmt.Errorf("cassette %s: %s %s body %s does not match the recorded body %s",
+				r.path, req.Method, url, reqBody, in.Request.Body)
+		}
+		r.used[i] = true
+		respBody := in.Response.body()
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Response.StatusCode, http.StatusText(in.Response.StatusCode)),
+			StatusCode:    in.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        in.Response.Header.Clone(),
+			Body:          io.NopCloser(bytes.NewReader(respBody)),
+			ContentLength: int64(len(respBody)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("cassette %s: no recorded interaction for %s %s", r.path, req.Method, url)
+}
+
+// Stop finishes the recording. In ModeRecord it writes the cassette file, in
+// ModeReplay it reports recorded interactions that were never requested.
+func (r *Recorder) Stop() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.mode == ModeReplay {
+		for i, used := range r.used {
+			if !used {
+				in := r.cassette.Interactions[i]
+				return fmt.Errorf("cassette %s: recorded interaction %s %s was not requested", r.path, in.Request.Method, in.Request.URL)
+			}
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(r.cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, append(data, '\n'), 0o644)
+}
+
+func scrubHeader(h http.Header) http.Header {
+	out := h.Clone()
+	for _, name := range scrubbedHeaders {
+		if out.Get(name) != "" {
+			out.Set(name, redacted)
+		}
+	}
+	return out
+}
+
+// scrubBody masks passwords in body. JSON bodies are returned in the first
+// result, any other body in the second with only connection strings masked.
+func scrubBody(body string) (json.RawMessage, string) {
+	if body == "" {
+		return nil, ""
+	}
+	var v any
+	if err := json.Unmarshal([]byte(body), &v); err != nil {
+		return nil, scrubString(body)
+	}
+	data, err := json.Marshal(scrubValue(v))
+	if err != nil {
+		return nil, scrubString(body)
+	}
+	return data, ""
+}
+
+func scrubValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, val := range v {
+			if s, ok := val.(string); ok && k == "password" && s != "" {
+				v[k] = redacted
+				continue
+			}
+			v[k] = scrubValue(val)
+		}
+		return v
+	case []any:
+		for i := range v {
+			v[i] = scrubValue(v[i])
+		}
+		return v
+	case string:
+		return scrubString(v)
+	}
+	return v
+}
+
+func scrubString(s string) string {
+	return redact.String(s)
+}
+
+func jsonEqual(a, b json.RawMessage) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+	var va, vb any
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
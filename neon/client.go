@@ -1,11 +1,200 @@
 package neon
 
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/evanshortiss/neon-kube-operator/neon/redact"
+)
+
+const (
+	// DefaultBaseURL is the Neon API v2 endpoint used unless WithBaseURL is set.
+	DefaultBaseURL = "https://console.neon.tech/api/v2"
+	// DefaultUserAgent is sent with every request unless WithUserAgent is set.
+	DefaultUserAgent = "neon-kube-operator"
+)
+
+// ErrNoAPIKey is returned for calls made while the client has no API key.
+var ErrNoAPIKey = errors.New("no Neon API key is configured")
+
 type Client struct {
-	apiKey string
+	// apiKey holds a string so that the key can be replaced while requests
+	// are in flight. rejected is set when Neon answered 401 with it.
+	apiKey   atomic.Value
+	rejected atomic.Bool
+	// redactId is the id the API key is registered under for redaction.
+	redactId string
+
+	baseURL     string
+	userAgent   string
+	httpClient  *http.Client
+	retryPolicy RetryPolicy
+	rateLimit   RateLimit
+	rateLimiter *RateLimiter
+	breaker     *CircuitBreaker
+}
+
+// ClientOption configures a Client created by CreateClient.
+type ClientOption func(*Client)
+
+// WithBaseURL sets the Neon API base URL, e.g. to target a local stand-in.
+func WithBaseURL(baseURL string) ClientOption {
+	return func(c *Client) {
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithHTTPClient sets the HTTP client used for all requests. The client is
+// copied, so later options such as WithTimeout do not modify it.
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(c *Client) {
+		hc := *httpClient
+		c.httpClient = &hc
+	}
+}
+
+// WithTransport sets the RoundTripper used for all requests.
+func WithTransport(rt http.RoundTripper) ClientOption {
+	return func(c *Client) {
+		c.httpClient.Transport = rt
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every request.
+func WithUserAgent(userAgent string) ClientOption {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// WithTimeout sets the time limit for each API call, including retries and
+// reading the response body. Zero means no timeout.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.httpClient.Timeout = timeout
+	}
+}
+
+func CreateClient(apiKey string, opts ...ClientOption) *Client {
+	c := &Client{
+		baseURL:     DefaultBaseURL,
+		userAgent:   DefaultUserAgent,
+		httpClient:  &http.Client{},
+		retryPolicy: DefaultRetryPolicy,
+		rateLimit:   DefaultRateLimit,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.httpClient.Transport = c.wrapTransport(c.httpClient.Transport)
+	c.redactId = fmt.Sprintf("neon api key %p", c)
+	redact.Register(c.redactId, apiKey)
+	c.apiKey.Store(apiKey)
+	return c
 }
 
-func CreateClient(apiKey string) *Client {
-	return &Client{
-		apiKey: apiKey,
+// SetAPIKey replaces the API key used by later requests. The previous key
+// is no longer masked in logs unless another client uses it.
+func (c *Client) SetAPIKey(apiKey string) {
+	redact.Register(c.redactId, apiKey)
+	c.apiKey.Store(apiKey)
+	c.rejected.Store(false)
+}
+
+// Close unregisters the API key of the client from redaction. It is called
+// when the client is dropped and no longer used.
+func (c *Client) Close() {
+	redact.Unregister(c.redactId)
+}
+
+// CheckAPIKey returns an error while the client has no API key or Neon
+// rejected the key on the most recent request.
+func (c *Client) CheckAPIKey() error {
+	if c.apiKey.Load().(string) == "" {
+		return ErrNoAPIKey
+	}
+	if c.rejected.Load() {
+		return errors.New("the Neon API key was rejected")
+	}
+	return nil
+}
+
+// wrapTransport adds the client's middleware around rt. Every attempt made
+// by the retry transport waits on the rate limiter and is then recorded in
+// the metrics, and all attempts of a call share one trace span, one audit
+// event and one outcome reported to the circuit breaker.
+func (c *Client) wrapTransport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	rt = &metricsTransport{next: rt}
+	limiter := c.rateLimiter
+	if limiter == nil {
+		limiter = NewRateLimiter(c.rateLimit)
+	}
+	rt = &rateLimitTransport{next: rt, limiter: limiter}
+	rt = &retryTransport{next: rt, policy: c.retryPolicy}
+	if c.breaker != nil {
+		rt = &breakerTransport{next: rt, breaker: c.breaker}
+	}
+	rt = &auditTransport{next: rt}
+	return &tracingTransport{next: rt}
+}
+
+// newRequest builds a request for path, which is relative to the base URL.
+// body is encoded as JSON when not nil.
+func (c *Client) newRequest(ctx context.Context, method, path string, body any) (*http.Request, error) {
+	apiKey := c.apiKey.Load().(string)
+	if apiKey == "" {
+		return nil, ErrNoAPIKey
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Authorization", "Bearer "+apiKey)
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("User-Agent", c.userAgent)
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// do sends a request built by newRequest. The caller must close the body of
+// the returned response.
+func (c *Client) do(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	req, err := c.newRequest(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		c.rejected.Store(true)
+	} else if resp.StatusCode < 400 {
+		c.rejected.Store(false)
 	}
+	return resp, nil
 }
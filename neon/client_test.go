@@ -0,0 +1,851 @@
+package neon_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	neontechv1alpha1 "github.com/evanshortiss/neon-kube-operator/api/v1alpha1"
+	"github.com/evanshortiss/neon-kube-operator/neon"
+	"github.com/evanshortiss/neon-kube-operator/neon/neontest"
+	"github.com/evanshortiss/neon-kube-operator/neon/redact"
+)
+
+func newBranch(projectId, name string) *neontechv1alpha1.Branch {
+	return &neontechv1alpha1.Branch{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       neontechv1alpha1.BranchSpec{ProjectId: projectId},
+	}
+}
+
+// roundTripFunc is an http.RoundTripper calling itself.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestDecodeRequiresFields(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{
+			name: "complete",
+			body: `{"branch":{"id":"br-1","project_id":"p-1","name":"feature","created_at":"2023-06-01T00:00:00Z"}}`,
+		},
+		{
+			name:    "missing id",
+			body:    `{"branch":{"project_id":"p-1","name":"feature","created_at":"2023-06-01T00:00:00Z"}}`,
+			wantErr: `branch is missing required field "id"`,
+		},
+		{
+			name:    "missing name",
+			body:    `{"branch":{"id":"br-1","project_id":"p-1","created_at":"2023-06-01T00:00:00Z"}}`,
+			wantErr: `branch is missing required field "name"`,
+		},
+		{
+			name:    "malformed",
+			body:    `{"branch":[]}`,
+			wantErr: "failed to decode",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = io.WriteString(w, tc.body)
+			}))
+			t.Cleanup(server.Close)
+
+			branch := &neontechv1alpha1.Branch{
+				ObjectMeta: metav1.ObjectMeta{Name: "feature", Namespace: "default"},
+				Spec:       neontechv1alpha1.BranchSpec{ProjectId: "p-1"},
+				Status:     neontechv1alpha1.BranchStatus{Id: "br-1"},
+			}
+			resp, err := neon.CreateClient("key", neon.WithBaseURL(server.URL)).GetBranch(context.Background(), branch)
+			if tc.wantErr == "" {
+				if err != nil || resp.Branch.Id != "br-1" {
+					t.Fatalf("GetBranch = %+v, %v", resp, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("GetBranch error = %v, want %q", err, tc.wantErr)
+			}
+			if errors.Is(err, neon.ErrBranchNotFound) {
+				t.Error("a malformed branch was reported as not found")
+			}
+		})
+	}
+}
+
+func TestClientOptions(t *testing.T) {
+	var got *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = req
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"branch":{"id":"br-1","project_id":"p-1","name":"feature","created_at":"2023-06-01T00:00:00Z"}}`)
+	}))
+	t.Cleanup(server.Close)
+	used := false
+	httpClient := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		used = true
+		return http.DefaultTransport.RoundTrip(req)
+	})}
+	client := neon.CreateClient("key",
+		neon.WithBaseURL(server.URL+"/api/v2/"),
+		neon.WithHTTPClient(httpClient),
+		neon.WithUserAgent("neon-kube-operator-test"),
+	)
+
+	branch := &neontechv1alpha1.Branch{
+		ObjectMeta: metav1.ObjectMeta{Name: "feature", Namespace: "default"},
+		Spec:       neontechv1alpha1.BranchSpec{ProjectId: "p-1"},
+		Status:     neontechv1alpha1.BranchStatus{Id: "br-1"},
+	}
+	if _, err := client.GetBranch(context.Background(), branch); err != nil {
+		t.Fatalf("GetBranch: %v", err)
+	}
+	if !used {
+		t.Error("the configured HTTP client was not used")
+	}
+	if got.URL.Path != "/api/v2/projects/p-1/branches/br-1" {
+		t.Errorf("request path = %q", got.URL.Path)
+	}
+	if ua := got.Header.Get("User-Agent"); ua != "neon-kube-operator-test" {
+		t.Errorf("User-Agent = %q", ua)
+	}
+	if auth := got.Header.Get("Authorization"); auth != "Bearer key" {
+		t.Errorf("Authorization = %q", auth)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		projectId := strings.Split(req.URL.Path, "/")[2]
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"branch":{"id":"br-1","project_id":"`+projectId+`","name":"feature","created_at":"2023-06-01T00:00:00Z"}}`)
+	}))
+	t.Cleanup(server.Close)
+	getBranch := func(client *neon.Client, projectId string) error {
+		// A request that would wait for a token fails right away, since the
+		// limiter knows it cannot get one before the deadline.
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err := client.GetBranch(ctx, &neontechv1alpha1.Branch{
+			Spec:   neontechv1alpha1.BranchSpec{ProjectId: projectId},
+			Status: neontechv1alpha1.BranchStatus{Id: "br-1"},
+		})
+		return err
+	}
+
+	// One request is allowed, the next one would wait for 1000 seconds.
+	global := neon.CreateClient("key", neon.WithBaseURL(server.URL), neon.WithRateLimit(neon.RateLimit{QPS: 0.001, Burst: 1}))
+	if err := getBranch(global, "p-1"); err != nil {
+		t.Fatalf("GetBranch: %v", err)
+	}
+	if err := getBranch(global, "p-2"); err == nil {
+		t.Error("expected the global limit to apply across projects")
+	}
+
+	project := neon.CreateClient("key", neon.WithBaseURL(server.URL), neon.WithRateLimit(neon.RateLimit{ProjectQPS: 0.001, ProjectBurst: 1}))
+	if err := getBranch(project, "p-1"); err != nil {
+		t.Fatalf("GetBranch: %v", err)
+	}
+	if err := getBranch(project, "p-1"); err == nil {
+		t.Error("expected the project limit to apply")
+	}
+	if err := getBranch(project, "p-2"); err != nil {
+		t.Errorf("another project was limited: %v", err)
+	}
+}
+
+func TestCreateBranchWaitsForOperations(t *testing.T) {
+	server := neontest.NewServer(t, neontest.WithOperationDuration(50*time.Millisecond))
+	projectId := server.AddProject("test")
+	client := server.Client()
+	ctx := context.Background()
+
+	branch := newBranch(projectId, "feature")
+	resp, err := client.CreateBranch(ctx, branch)
+	if err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	if resp.Branch.Name != "feature" || resp.Branch.ProjectId != projectId {
+		t.Errorf("unexpected branch %+v", resp.Branch)
+	}
+
+	ops := neon.OperationIds(resp.Operations)
+	if len(ops) != 1 {
+		t.Fatalf("expected one pending operation, got %v", resp.Operations)
+	}
+	pending, err := client.PendingOperations(ctx, projectId, ops)
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("expected the operation to be pending, got %v, %v", pending, err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	pending, err = client.PendingOperations(ctx, projectId, ops)
+	if err != nil || len(pending) != 0 {
+		t.Fatalf("expected the operation to be finished, got %v, %v", pending, err)
+	}
+
+	branch.Status.Id = resp.Branch.Id
+	got, err := client.GetBranch(ctx, branch)
+	if err != nil {
+		t.Fatalf("GetBranch: %v", err)
+	}
+	if status := neon.NewBranchStatus(got.Branch); status.Id != resp.Branch.Id || status.Name != "feature" {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestFailedOperation(t *testing.T) {
+	server := neontest.NewServer(t, neontest.WithOperationDuration(time.Hour))
+	projectId := server.AddProject("test")
+	client := server.Client()
+	ctx := context.Background()
+
+	resp, err := client.CreateBranch(ctx, newBranch(projectId, "feature"))
+	if err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	server.SetOperationStatus(resp.Operations[0].Id, neon.OperationStatusFailed)
+
+	pending, err := client.PendingOperations(ctx, projectId, neon.OperationIds(resp.Operations))
+	var opErr *neon.OperationFailedError
+	if !errors.As(err, &opErr) {
+		t.Fatalf("expected an OperationFailedError, got %v", err)
+	}
+	if len(pending) != 1 || pending[0] != resp.Operations[0].Id {
+		t.Errorf("failed operation missing from pending %v", pending)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("test")
+	client := server.Client()
+
+	server.InjectFailure(neontest.Failure{
+		Method:     http.MethodPost,
+		Path:       "/projects/*/branches",
+		StatusCode: http.StatusUnprocessableEntity,
+		Code:       "BRANCHES_LIMIT_EXCEEDED",
+		Message:    "branches limit exceeded",
+	})
+
+	_, err := client.CreateBranch(context.Background(), newBranch(projectId, "feature"))
+	var apiErr *neon.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an APIError, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusUnprocessableEntity || apiErr.Code != "BRANCHES_LIMIT_EXCEEDED" || apiErr.RequestId == "" {
+		t.Errorf("unexpected error %+v", apiErr)
+	}
+	if msg := neon.ErrorMessage(err); msg != "failed to create branch: branches limit exceeded" {
+		t.Errorf("unexpected message %q", msg)
+	}
+	server.ExpectRequests(t, http.MethodPost, "/projects/*/branches", 1)
+}
+
+func TestRetries(t *testing.T) {
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("test")
+	client := server.Client()
+
+	server.InjectFailure(neontest.Failure{
+		Path:       "/projects/*/branches",
+		StatusCode: http.StatusLocked,
+		Times:      2,
+	})
+	if _, err := client.CreateBranch(context.Background(), newBranch(projectId, "feature")); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	server.ExpectRequests(t, http.MethodPost, "/projects/*/branches", 3)
+
+	server.InjectFailure(neontest.Failure{
+		Path:       "/projects/*/branches",
+		StatusCode: http.StatusInternalServerError,
+	})
+	_, err := client.CreateBranch(context.Background(), newBranch(projectId, "other"))
+	if err == nil {
+		t.Fatal("expected CreateBranch to fail")
+	}
+	server.ExpectRequests(t, http.MethodPost, "/projects/*/branches", 4)
+}
+
+func TestListBranchesPaginates(t *testing.T) {
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("test")
+	for i := 0; i < 150; i++ {
+		if _, err := server.AddBranch(projectId, fmt.Sprintf("branch-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := server.Client()
+
+	branches, err := client.ListBranches(context.Background(), projectId)
+	if err != nil {
+		t.Fatalf("ListBranches: %v", err)
+	}
+	// The primary branch is listed as well.
+	if len(branches) != 151 {
+		t.Errorf("expected 151 branches, got %d", len(branches))
+	}
+	server.ExpectRequests(t, http.MethodGet, "/projects/*/branches", 2)
+}
+
+func TestFindBranchAndEndpoint(t *testing.T) {
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("test")
+	client := server.Client()
+	ctx := context.Background()
+
+	branch := newBranch(projectId, "feature")
+	if _, err := client.FindBranch(ctx, branch, nil); !errors.Is(err, neon.ErrBranchNotFound) {
+		t.Fatalf("expected ErrBranchNotFound, got %v", err)
+	}
+	created, err := client.CreateBranch(ctx, branch)
+	if err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	found, err := client.FindBranch(ctx, branch, nil)
+	if err != nil || found.Id != created.Branch.Id {
+		t.Fatalf("expected to find branch %s, got %+v, %v", created.Branch.Id, found, err)
+	}
+
+	endpoint := &neontechv1alpha1.Endpoint{
+		ObjectMeta: metav1.ObjectMeta{Name: "feature", Namespace: "default"},
+		Spec: neontechv1alpha1.EndpointSpec{
+			BranchFrom: neontechv1alpha1.BranchFrom{ProjectId: projectId, BranchId: found.Id},
+			Type:       string(neontechv1alpha1.EndpointTypeReadOnly),
+		},
+	}
+	if _, err := client.FindEndpoint(ctx, nil, endpoint, nil); !errors.Is(err, neon.ErrEndpointNotFound) {
+		t.Fatalf("expected ErrEndpointNotFound, got %v", err)
+	}
+	ep, err := client.CreateEndpoint(ctx, nil, endpoint)
+	if err != nil {
+		t.Fatalf("CreateEndpoint: %v", err)
+	}
+	if got, err := client.FindEndpoint(ctx, nil, endpoint, nil); err != nil || got.Id != ep.Endpoint.Id {
+		t.Fatalf("expected to find endpoint %s, got %+v, %v", ep.Endpoint.Id, got, err)
+	}
+
+	// A second read only endpoint makes the match ambiguous.
+	if _, err := client.CreateEndpoint(ctx, nil, endpoint); err != nil {
+		t.Fatalf("CreateEndpoint: %v", err)
+	}
+	if _, err := client.FindEndpoint(ctx, nil, endpoint, nil); err == nil || errors.Is(err, neon.ErrEndpointNotFound) {
+		t.Fatalf("expected an ambiguity error, got %v", err)
+	}
+	// Objects owned by other resources are never found.
+	owned := map[string]bool{ep.Endpoint.Id: true}
+	if got, err := client.FindEndpoint(ctx, nil, endpoint, owned); err != nil || got.Id == ep.Endpoint.Id {
+		t.Fatalf("expected to find the unowned endpoint, got %+v, %v", got, err)
+	}
+	owned = map[string]bool{created.Branch.Id: true}
+	if _, err := client.FindBranch(ctx, branch, owned); !errors.Is(err, neon.ErrBranchNotFound) {
+		t.Fatalf("expected ErrBranchNotFound for an owned branch, got %v", err)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(trace.NewNoopTracerProvider()) })
+
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("test")
+	client := server.Client()
+	server.InjectFailure(neontest.Failure{Path: "/projects/*/branches", StatusCode: http.StatusLocked, Times: 1})
+
+	resp, err := client.CreateBranch(context.Background(), newBranch(projectId, "feature"))
+	if err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected one span, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "neon POST /projects/{project_id}/branches" {
+		t.Errorf("unexpected span name %q", span.Name())
+	}
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	if attrs[neon.ProjectIdKey].AsString() != projectId || attrs["http.status_code"].AsInt64() != http.StatusCreated {
+		t.Errorf("unexpected attributes %v", span.Attributes())
+	}
+	if events := span.Events(); len(events) != 1 || events[0].Name != "retry" {
+		t.Errorf("expected a retry event, got %v", events)
+	}
+	if resp.Branch.Id == "" {
+		t.Error("expected a branch id")
+	}
+}
+
+// requestCount returns the neon_api_requests_total sample with the labels.
+func requestCount(t *testing.T, method, route, code string) float64 {
+	t.Helper()
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"method": method, "route": route, "code": code}
+	for _, family := range families {
+		if family.GetName() != "neon_api_requests_total" {
+			continue
+		}
+	samples:
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if want[label.GetName()] != label.GetValue() {
+					continue samples
+				}
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestRequestMetrics(t *testing.T) {
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("test")
+	client := server.Client()
+
+	route := "/projects/{project_id}/branches"
+	created := requestCount(t, http.MethodPost, route, "201")
+	throttled := requestCount(t, http.MethodPost, route, "429")
+
+	server.InjectFailure(neontest.Failure{Path: "/projects/*/branches", StatusCode: http.StatusTooManyRequests, Times: 2})
+	if _, err := client.CreateBranch(context.Background(), newBranch(projectId, "feature")); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+
+	if got := requestCount(t, http.MethodPost, route, "429") - throttled; got != 2 {
+		t.Errorf("expected 2 rate limited requests, got %v", got)
+	}
+	if got := requestCount(t, http.MethodPost, route, "201") - created; got != 1 {
+		t.Errorf("expected 1 successful request, got %v", got)
+	}
+}
+
+func TestCacheServesReads(t *testing.T) {
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("test")
+	cache := neon.NewCache(server.Client(), time.Hour)
+	ctx := context.Background()
+
+	branch := newBranch(projectId, "feature")
+	resp, err := cache.CreateBranch(ctx, branch)
+	if err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	branch.Status.Id = resp.Branch.Id
+	for i := 0; i < 3; i++ {
+		if _, err := cache.GetBranch(ctx, branch); err != nil {
+			t.Fatalf("GetBranch: %v", err)
+		}
+		if _, err := cache.FindBranch(ctx, branch, nil); err != nil {
+			t.Fatalf("FindBranch: %v", err)
+		}
+		if _, err := cache.GetFirstRole(ctx, projectId, branch.Status.Id); err != nil {
+			t.Fatalf("GetFirstRole: %v", err)
+		}
+	}
+	server.ExpectRequests(t, http.MethodGet, "/projects/*/branches", 1)
+	server.ExpectRequests(t, http.MethodGet, "/projects/*/endpoints", 1)
+	server.ExpectRequests(t, http.MethodGet, "/projects/*/branches/*/roles", 1)
+	server.ExpectRequests(t, http.MethodGet, "/projects/*/branches/*", 0)
+
+	// Writes through the cache make the next read list the project again.
+	if _, err := cache.DeleteBranch(ctx, branch); err != nil {
+		t.Fatalf("DeleteBranch: %v", err)
+	}
+	if _, err := cache.GetBranch(ctx, branch); !errors.Is(err, neon.ErrBranchNotFound) {
+		t.Fatalf("expected ErrBranchNotFound, got %v", err)
+	}
+	server.ExpectRequests(t, http.MethodGet, "/projects/*/branches", 2)
+}
+
+func TestCacheDropsMissingProjects(t *testing.T) {
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("test")
+	cache := neon.NewCache(server.Client(), 10*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = cache.Start(ctx) }()
+
+	// A mistyped project is not kept after its first list fails.
+	if _, err := cache.FindBranch(ctx, newBranch("p-mistyped", "feature"), nil); err == nil {
+		t.Fatal("expected FindBranch to fail for a missing project")
+	}
+	if _, err := cache.FindBranch(ctx, newBranch(projectId, "feature"), nil); !errors.Is(err, neon.ErrBranchNotFound) {
+		t.Fatalf("expected ErrBranchNotFound, got %v", err)
+	}
+
+	// A project deleted outside the cache is dropped once a refresh finds
+	// it gone.
+	project := &neontechv1alpha1.Project{Status: neontechv1alpha1.ProjectStatus{Id: projectId}}
+	if _, err := server.Client().DeleteProject(context.Background(), project); err != nil {
+		t.Fatalf("DeleteProject: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	lists := server.RequestCount(http.MethodGet, "/projects/*/branches")
+	time.Sleep(100 * time.Millisecond)
+	if n := server.RequestCount(http.MethodGet, "/projects/*/branches"); n != lists {
+		t.Errorf("missing projects are still refreshed, %d lists became %d", lists, n)
+	}
+	server.ExpectRequests(t, http.MethodGet, "/projects/p-mistyped/branches", 1)
+}
+
+func TestCacheMissAsksNeon(t *testing.T) {
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("test")
+	cache := neon.NewCache(server.Client(), time.Hour)
+	ctx := context.Background()
+	if _, err := cache.ListBranches(ctx, projectId); err != nil {
+		t.Fatalf("ListBranches: %v", err)
+	}
+
+	// Objects created by another client are missing from the snapshot.
+	branch := newBranch(projectId, "feature")
+	resp, err := server.Client().CreateBranch(ctx, branch)
+	if err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	branch.Status.Id = resp.Branch.Id
+	if got, err := cache.GetBranch(ctx, branch); err != nil || got.Branch.Id != branch.Status.Id {
+		t.Fatalf("GetBranch = %+v, %v", got, err)
+	}
+	server.ExpectRequests(t, http.MethodGet, "/projects/*/branches/*", 1)
+
+	// The miss made the next read list the project again.
+	if _, err := cache.ListBranches(ctx, projectId); err != nil {
+		t.Fatalf("ListBranches: %v", err)
+	}
+	server.ExpectRequests(t, http.MethodGet, "/projects/*/branches", 2)
+
+	endpoint := &neontechv1alpha1.Endpoint{
+		ObjectMeta: metav1.ObjectMeta{Name: "feature", Namespace: "default"},
+		Spec: neontechv1alpha1.EndpointSpec{
+			BranchFrom: neontechv1alpha1.BranchFrom{ProjectId: projectId, BranchId: branch.Status.Id},
+			Type:       string(neontechv1alpha1.EndpointTypeReadOnly),
+		},
+	}
+	ep, err := server.Client().CreateEndpoint(ctx, nil, endpoint)
+	if err != nil {
+		t.Fatalf("CreateEndpoint: %v", err)
+	}
+	endpoint.Status.Id = ep.Endpoint.Id
+	if got, err := cache.GetEndpoint(ctx, nil, endpoint); err != nil || got.Endpoint.Id != endpoint.Status.Id {
+		t.Fatalf("GetEndpoint = %+v, %v", got, err)
+	}
+	server.ExpectRequests(t, http.MethodGet, "/projects/*/endpoints/*", 1)
+}
+
+// auditRecorder collects audit events.
+type auditRecorder struct {
+	events []neon.AuditEvent
+}
+
+func (r *auditRecorder) Audit(_ context.Context, event neon.AuditEvent) {
+	r.events = append(r.events, event)
+}
+
+func TestAudit(t *testing.T) {
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("test")
+	client := server.Client()
+	auditor := &auditRecorder{}
+	ctx := neon.ContextWithAuditor(context.Background(), auditor)
+
+	branch := newBranch(projectId, "feature")
+	resp, err := client.CreateBranch(ctx, branch)
+	if err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	branch.Status.Id = resp.Branch.Id
+	role, err := client.GetFirstRole(ctx, projectId, branch.Status.Id)
+	if err != nil {
+		t.Fatalf("GetFirstRole: %v", err)
+	}
+	if _, err := client.GetRolePassword(ctx, projectId, branch.Status.Id, role); err != nil {
+		t.Fatalf("GetRolePassword: %v", err)
+	}
+	server.InjectFailure(neontest.Failure{
+		Method:     http.MethodDelete,
+		Path:       "/projects/*/branches/*",
+		StatusCode: http.StatusForbidden,
+	})
+	if _, err := client.DeleteBranch(ctx, branch); err == nil {
+		t.Fatal("expected DeleteBranch to fail")
+	}
+
+	if len(auditor.events) != 3 {
+		t.Fatalf("expected 3 audit events, got %+v", auditor.events)
+	}
+	create, reveal, del := auditor.events[0], auditor.events[1], auditor.events[2]
+	if create.Action != "create" || !create.Succeeded() || create.BranchId != resp.Branch.Id ||
+		len(create.OperationIds) != 1 || create.OperationIds[0] != resp.Operations[0].Id {
+		t.Errorf("unexpected create event %+v", create)
+	}
+	if reveal.Action != "reveal_password" || !reveal.Succeeded() || reveal.BranchId != resp.Branch.Id {
+		t.Errorf("unexpected reveal event %+v", reveal)
+	}
+	if del.Action != "delete" || del.Succeeded() || del.StatusCode != http.StatusForbidden {
+		t.Errorf("unexpected delete event %+v", del)
+	}
+}
+
+func TestSetAPIKey(t *testing.T) {
+	server := neontest.NewServer(t, neontest.WithAPIKey("secret"))
+	client := server.Client()
+	ctx := context.Background()
+
+	client.SetAPIKey("")
+	if _, err := client.ListProjects(ctx); !errors.Is(err, neon.ErrNoAPIKey) {
+		t.Fatalf("expected ErrNoAPIKey, got %v", err)
+	}
+	if err := client.CheckAPIKey(); !errors.Is(err, neon.ErrNoAPIKey) {
+		t.Errorf("expected ErrNoAPIKey, got %v", err)
+	}
+	server.ExpectRequests(t, "", "", 0)
+
+	client.SetAPIKey("wrong")
+	if _, err := client.ListProjects(ctx); !neon.IsUnauthorized(err) {
+		t.Fatalf("expected an unauthorized error, got %v", err)
+	}
+	if err := client.CheckAPIKey(); err == nil {
+		t.Error("expected the rejected key to be reported")
+	}
+
+	client.SetAPIKey("secret")
+	if _, err := client.ListProjects(ctx); err != nil {
+		t.Fatalf("ListProjects: %v", err)
+	}
+	if err := client.CheckAPIKey(); err != nil {
+		t.Errorf("CheckAPIKey: %v", err)
+	}
+}
+
+func TestRedactedSecrets(t *testing.T) {
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("shop")
+	var branchId string
+	for _, b := range server.Branches(projectId) {
+		if b.Primary {
+			branchId = b.Id
+		}
+	}
+	ctx := context.Background()
+
+	// API keys are masked until they are replaced or their client is
+	// closed.
+	client := neon.CreateClient("first-redacted-key")
+	client.SetAPIKey("second-redacted-key")
+	if got := redact.String("first-redacted-key second-redacted-key"); got != "first-redacted-key "+redact.Mask {
+		t.Errorf("unexpected %q after replacing the key", got)
+	}
+	client.Close()
+	if got := redact.String("second-redacted-key"); got != "second-redacted-key" {
+		t.Errorf("the key of a closed client is still masked: %q", got)
+	}
+
+	// Role passwords are masked until the role is deleted.
+	role := &neontechv1alpha1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Status:     neontechv1alpha1.RoleStatus{ProjectId: projectId, BranchId: branchId},
+	}
+	resp, err := server.Client().CreateRole(ctx, role)
+	if err != nil {
+		t.Fatalf("CreateRole: %v", err)
+	}
+	password := resp.Role.Password
+	if got := redact.String(password); got != redact.Mask {
+		t.Errorf("the password of a new role is not masked: %q", got)
+	}
+	role.Status.Name = resp.Role.Name
+	if _, err := server.Client().DeleteRole(ctx, role); err != nil {
+		t.Fatalf("DeleteRole: %v", err)
+	}
+	if got := redact.String(password); got != password {
+		t.Errorf("the password of a deleted role is still masked: %q", got)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	server := neontest.NewServer(t)
+	breaker := neon.NewCircuitBreaker(2, 50*time.Millisecond)
+	client := server.Client(
+		neon.WithRetryPolicy(neon.RetryPolicy{MaxAttempts: 1}),
+		neon.WithCircuitBreaker(breaker),
+	)
+	ctx := context.Background()
+
+	server.InjectFailure(neontest.Failure{
+		Method:     http.MethodGet,
+		Path:       "/projects",
+		StatusCode: http.StatusServiceUnavailable,
+	})
+	for i := 0; i < 2; i++ {
+		if _, err := client.ListProjects(ctx); errors.Is(err, neon.ErrCircuitOpen) || err == nil {
+			t.Fatalf("expected the API error, got %v", err)
+		}
+	}
+	_, err := client.ListProjects(ctx)
+	if !errors.Is(err, neon.ErrCircuitOpen) || !errors.Is(err, neon.ErrRetryAgain) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	if err := breaker.Check(); !errors.Is(err, neon.ErrCircuitOpen) {
+		t.Errorf("expected the breaker to be open, got %v", err)
+	}
+	server.ExpectRequests(t, http.MethodGet, "/projects", 2)
+
+	// A failed probe keeps the breaker open, a successful one closes it.
+	time.Sleep(60 * time.Millisecond)
+	if _, err := client.ListProjects(ctx); err == nil || errors.Is(err, neon.ErrCircuitOpen) {
+		t.Fatalf("expected the probe to fail, got %v", err)
+	}
+	if _, err := client.ListProjects(ctx); !errors.Is(err, neon.ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen after a failed probe, got %v", err)
+	}
+	server.ClearFailures()
+	time.Sleep(60 * time.Millisecond)
+	if _, err := client.ListProjects(ctx); err != nil {
+		t.Fatalf("ListProjects: %v", err)
+	}
+	if err := breaker.Check(); err != nil {
+		t.Errorf("expected the breaker to be closed, got %v", err)
+	}
+	server.ExpectRequests(t, http.MethodGet, "/projects", 4)
+}
+
+func TestSharedRateLimiter(t *testing.T) {
+	server := neontest.NewServer(t)
+	projectId := server.AddProject("test")
+	// One request is allowed, the next one would wait for 1000 seconds.
+	limit := neon.RateLimit{QPS: 0.001, Burst: 1}
+	limiter := neon.NewRateLimiter(limit)
+	first := server.Client(neon.WithRateLimiter(limiter))
+	second := server.Client(neon.WithRateLimiter(limiter))
+	own := server.Client(neon.WithRateLimit(limit))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := first.ListBranches(ctx, projectId); err != nil {
+		t.Fatalf("ListBranches: %v", err)
+	}
+	if _, err := second.ListBranches(ctx, projectId); err == nil {
+		t.Error("expected the second client to be limited along with the first")
+	}
+	if _, err := own.ListBranches(ctx, projectId); err != nil {
+		t.Errorf("a client with its own limiter was limited: %v", err)
+	}
+
+	// Each API key has a limit of its own.
+	other := server.Client(neon.WithRateLimiter(limiter))
+	other.SetAPIKey("other-key")
+	if _, err := other.ListBranches(ctx, projectId); err != nil {
+		t.Errorf("a client with another API key was limited: %v", err)
+	}
+}
+
+func TestRateLimiterDropsFullBuckets(t *testing.T) {
+	server := neontest.NewServer(t)
+	first := server.AddProject("first")
+	second := server.AddProject("second")
+	// API key buckets refill within a millisecond, project buckets do not.
+	limiter := neon.NewRateLimiter(neon.RateLimit{QPS: 1000, Burst: 1, ProjectQPS: 0.001, ProjectBurst: 1})
+	a := server.Client(neon.WithRateLimiter(limiter))
+	b := server.Client(neon.WithRateLimiter(limiter))
+	b.SetAPIKey("other-key")
+
+	ctx := context.Background()
+	if _, err := a.ListBranches(ctx, first); err != nil {
+		t.Fatalf("ListBranches: %v", err)
+	}
+	if _, err := b.ListBranches(ctx, second); err != nil {
+		t.Fatalf("ListBranches: %v", err)
+	}
+	if keys, projects := limiter.Limiters(); keys != 2 || projects != 2 {
+		t.Fatalf("expected 2 key and 2 project limiters, got %d and %d", keys, projects)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	limiter.PruneLimiters()
+	if keys, projects := limiter.Limiters(); keys != 0 || projects != 2 {
+		t.Errorf("expected only the project limiters to be kept, got %d and %d", keys, projects)
+	}
+}
+
+func TestProjectLifecycle(t *testing.T) {
+	server := neontest.NewServer(t)
+	client := server.Client()
+	ctx := context.Background()
+
+	retention := int64(3600)
+	minCu, maxCu := 1, 2
+	project := &neontechv1alpha1.Project{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: neontechv1alpha1.ProjectSpec{
+			RegionId:                "aws-eu-central-1",
+			HistoryRetentionSeconds: &retention,
+			DefaultEndpointSettings: &neontechv1alpha1.DefaultEndpointSettings{
+				AutoscalingLimitMinCu: &minCu,
+				AutoscalingLimitMaxCu: &maxCu,
+			},
+		},
+	}
+	resp, err := client.CreateProject(ctx, project)
+	if err != nil {
+		t.Fatalf("CreateProject: %v", err)
+	}
+	if resp.Project.Name != "app" || resp.Project.RegionId != "aws-eu-central-1" || resp.Project.HistoryRetentionSeconds != retention {
+		t.Errorf("unexpected project %+v", resp.Project)
+	}
+	if resp.Branch == nil || len(resp.Endpoints) != 1 || resp.Endpoints[0].BranchId != resp.Branch.Id ||
+		resp.Endpoints[0].AutoscalingLimitMaxCu != 2 {
+		t.Fatalf("expected a default branch and endpoint, got %+v, %+v", resp.Branch, resp.Endpoints)
+	}
+
+	found, err := client.FindProject(ctx, project, nil)
+	if err != nil || found.Id != resp.Project.Id {
+		t.Fatalf("FindProject: %+v, %v", found, err)
+	}
+	project.Status = neon.NewProjectStatus(resp.Project)
+	if _, err := client.GetProject(ctx, project); err != nil {
+		t.Fatalf("GetProject: %v", err)
+	}
+	if _, err := client.DeleteProject(ctx, project); err != nil {
+		t.Fatalf("DeleteProject: %v", err)
+	}
+	if _, err := client.GetProject(ctx, project); !errors.Is(err, neon.ErrProjectNotFound) {
+		t.Errorf("expected ErrProjectNotFound, got %v", err)
+	}
+	if resp, err := client.DeleteProject(ctx, project); err != nil || resp != nil {
+		t.Errorf("expected deleting a missing project to succeed, got %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package neon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	neontechv1alpha1 "github.com/evanshortiss/neon-kube-operator/api/v1alpha1"
+)
+
+var ErrDatabaseNotFound = errors.New("database not found")
+
+func databaseSpecToRequestBody(d *neontechv1alpha1.Database) map[string]any {
+	return map[string]any{
+		"database": map[string]any{
+			"name":       d.NeonName(),
+			"owner_name": d.Spec.OwnerName,
+		},
+	}
+}
+
+// databasePath returns the path of the named database on the branch the
+// resource resolved to. Databases are addressed by name, so it is escaped.
+func databasePath(d *neontechv1alpha1.Database, name string) string {
+	return fmt.Sprintf("/projects/%s/branches/%s/databases/%s", d.Status.ProjectId, d.Status.BranchId, url.PathEscape(name))
+}
+
+// CreateDatabase creates the database on the branch recorded in the status
+// of the resource.
+func (c *Client) CreateDatabase(ctx context.Context, d *neontechv1alpha1.Database) (*DatabaseResponse, error) {
+	path := fmt.Sprintf("/projects/%s/branches/%s/databases", d.Status.ProjectId, d.Status.BranchId)
+	resp, err := c.do(ctx, http.MethodPost, path, databaseSpecToRequestBody(d))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != 201 {
+		return nil, fmt.Errorf("failed to create database: %w", newAPIError(resp))
+	}
+
+	var out DatabaseResponse
+	if err := decodeResponse(resp, &out); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
+
+// GetDatabase returns the database the resource created or adopted, which
+// is the one named in its status.
+func (c *Client) GetDatabase(ctx context.Context, d *neontechv1alpha1.Database) (*DatabaseResponse, error) {
+	if d.Status.Name == "" {
+		return nil, ErrDatabaseNotFound
+	}
+	return c.getDatabase(ctx, d, d.Status.Name)
+}
+
+func (c *Client) getDatabase(ctx context.Context, d *neontechv1alpha1.Database, name string) (*DatabaseResponse, error) {
+	resp, err := c.do(ctx, http.MethodGet, databasePath(d, name), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		if resp.StatusCode == 404 {
+			return nil, ErrDatabaseNotFound
+		}
+		return nil, fmt.Errorf("failed to get database: %w", newAPIError(resp))
+	}
+	var out DatabaseResponse
+	if err := decodeResponse(resp, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UpdateDatabase renames the database named in the status of the resource
+// to the name in its spec, and sets its owner.
+func (c *Client) UpdateDatabase(ctx context.Context, d *neontechv1alpha1.Database) (*DatabaseResponse, error) {
+	resp, err := c.do(ctx, http.MethodPatch, databasePath(d, d.Status.Name), databaseSpecToRequestBody(d))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("failed to update database: %w", newAPIError(resp))
+	}
+
+	var out DatabaseResponse
+	if err := decodeResponse(resp, &out); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
+
+// DeleteDatabase deletes the database named in the status of the resource.
+// It returns a nil response if the database no longer exists in Neon.
+func (c *Client) DeleteDatabase(ctx context.Context, d *neontechv1alpha1.Database) (*DatabaseResponse, error) {
+	resp, err := c.do(ctx, http.MethodDelete, databasePath(d, d.Status.Name), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode == 404 {
+		return nil, nil
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("failed to delete database: %w", newAPIError(resp))
+	}
+
+	var out DatabaseResponse
+	if err := decodeResponse(resp, &out); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
+
+// FindDatabase returns the database of the branch with the name in the spec
+// of the resource, or ErrDatabaseNotFound if there is none.
+func (c *Client) FindDatabase(ctx context.Context, d *neontechv1alpha1.Database) (*Database, error) {
+	resp, err := c.getDatabase(ctx, d, d.NeonName())
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Database, nil
+}
+
+func NewDatabaseStatus(database Database) neontechv1alpha1.DatabaseStatus {
+	return neontechv1alpha1.DatabaseStatus{
+		Id:        database.Id,
+		Name:      database.Name,
+		OwnerName: database.OwnerName,
+		BranchId:  database.BranchId,
+		CreatedAt: database.CreatedAt,
+		UpdatedAt: database.UpdatedAt,
+	}
+}
@@ -1,17 +1,12 @@
 package neon
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 
 	neontechv1alpha1 "github.com/evanshortiss/neon-kube-operator/api/v1alpha1"
-	kerrors "k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -66,110 +61,122 @@ func endpointSpecToCreateRequestBody(e *neontechv1alpha1.Endpoint, branchId, pro
 	return body
 }
 
-func (c *Client) CreateEndpoint(ctx context.Context, k8sClient client.Client, e *neontechv1alpha1.Endpoint) (map[string]any, error) {
+func (c *Client) CreateEndpoint(ctx context.Context, k8sClient client.Client, e *neontechv1alpha1.Endpoint) (*EndpointResponse, error) {
 	branchId, projectId, err := GetBranchProjectId(ctx, k8sClient, e)
 	if err != nil {
 		return nil, err
 	}
-	url := fmt.Sprintf("https://console.neon.tech/api/v2/projects/%s/endpoints", projectId)
-
-	reqData, err := json.Marshal(endpointSpecToCreateRequestBody(e, branchId, projectId))
-	if err != nil {
-		return nil, err
-	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(reqData))
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Add("Authorization", "Bearer "+c.apiKey)
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Content-Type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
+	path := fmt.Sprintf("/projects/%s/endpoints", projectId)
+	resp, err := c.do(ctx, http.MethodPost, path, endpointSpecToCreateRequestBody(e, branchId, projectId))
 	if err != nil {
 		return nil, err
 	}
 
 	defer resp.Body.Close()
 	if resp.StatusCode != 201 {
-		return nil, fmt.Errorf("Failed to create endpoint: %s", resp.Status)
-	}
-
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to create endpoint: %w", newAPIError(resp))
 	}
 
-	m := make(map[string]any)
-	err = json.Unmarshal(data, &m)
-	if err != nil {
+	var out EndpointResponse
+	if err := decodeResponse(resp, &out); err != nil {
 		return nil, err
 	}
 
-	return m, nil
+	return &out, nil
 }
 
 func GetBranchProjectId(ctx context.Context, k8sClient client.Client, e *neontechv1alpha1.Endpoint) (string, string, error) {
+	return ResolveBranchFrom(ctx, k8sClient, e.Namespace, e.Spec.BranchFrom)
+}
+
+// ResolveBranchFrom returns the branch and project ids selected by from for
+// a resource in namespace. It returns an error wrapping ErrRetryAgain until
+// the referenced Branch or Project exists in Neon.
+func ResolveBranchFrom(ctx context.Context, k8sClient client.Client, namespace string, from neontechv1alpha1.BranchFrom) (string, string, error) {
 	var branchId, projectId string
-	if e.Spec.BranchFrom.BranchRef != "" {
-		branch := neontechv1alpha1.Branch{}
-		err := k8sClient.Get(ctx, types.NamespacedName{Name: e.Spec.BranchFrom.BranchRef, Namespace: e.Namespace}, &branch)
-		if kerrors.IsNotFound(err) {
-			return "", "", fmt.Errorf("branch is not found yet, %w", ErrRetryAgain)
-		}
+	if from.BranchRef != "" {
+		branch, err := GetBranchRef(ctx, k8sClient, namespace, from.BranchRef)
 		if err != nil {
 			return "", "", err
 		}
-		if !branch.Status.State.Exists() {
-			return "", "", fmt.Errorf("branch status is not updated yet, %w", ErrRetryAgain)
-		}
 		branchId = branch.Status.Id
-		projectId = branch.Spec.ProjectId
+		projectId = branch.NeonProjectId()
 
+	} else if from.ProjectRef != "" {
+		project, err := GetProjectRef(ctx, k8sClient, namespace, from.ProjectRef)
+		if err != nil {
+			return "", "", err
+		}
+		projectId = project.Status.Id
+		branchId = from.BranchId
+		if branchId == "" {
+			branchId = project.Status.DefaultBranchId
+		}
 	} else {
-		branchId = e.Spec.BranchFrom.BranchId
-		projectId = e.Spec.BranchFrom.ProjectId
+		branchId = from.BranchId
+		projectId = from.ProjectId
 	}
 
 	return branchId, projectId, nil
 }
 
-func (c *Client) DeleteEndpoint(ctx context.Context, k8sClient client.Client, e *neontechv1alpha1.Endpoint) (map[string]any, error) {
-	url := fmt.Sprintf("https://console.neon.tech/api/v2/projects/%s/endpoints/%s", e.Status.ProjectId, e.Status.Id)
-	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, url, nil)
+// FindEndpoint returns the endpoint of the resource's type on the branch it
+// is created from, or ErrEndpointNotFound if there is none. Endpoints whose
+// ids are in owned belong to other resources and are skipped. Neon endpoints
+// have no name, so an error is returned if several endpoints match.
+func (c *Client) FindEndpoint(ctx context.Context, k8sClient client.Client, e *neontechv1alpha1.Endpoint, owned map[string]bool) (*Endpoint, error) {
+	branchId, projectId, err := GetBranchProjectId(ctx, k8sClient, e)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Add("Authorization", "Bearer "+c.apiKey)
-	req.Header.Add("Accept", "application/json")
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
+	var found []Endpoint
+	it := c.Endpoints(projectId)
+	for it.Next(ctx) {
+		if ep := it.Item(); ep.BranchId == branchId && ep.Type == e.Spec.Type && !owned[ep.Id] {
+			found = append(found, ep)
+		}
+	}
+	if err := it.Err(); err != nil {
 		return nil, err
 	}
-
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 && resp.StatusCode != 404 {
-		return nil, fmt.Errorf("failed to delete endpoint: %s", resp.Status)
+	switch len(found) {
+	case 0:
+		return nil, ErrEndpointNotFound
+	case 1:
+		return &found[0], nil
 	}
+	return nil, fmt.Errorf("found %d %s endpoints on branch %s, cannot choose one to adopt", len(found), e.Spec.Type, branchId)
+}
 
-	data, err := io.ReadAll(resp.Body)
+// DeleteEndpoint deletes the endpoint. It returns a nil response if the
+// endpoint no longer exists in Neon.
+func (c *Client) DeleteEndpoint(ctx context.Context, k8sClient client.Client, e *neontechv1alpha1.Endpoint) (*EndpointResponse, error) {
+	path := fmt.Sprintf("/projects/%s/endpoints/%s", e.Status.ProjectId, e.Status.Id)
+	resp, err := c.do(ctx, http.MethodDelete, path, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	m := make(map[string]any)
-	err = json.Unmarshal(data, &m)
-	if err != nil {
+	defer resp.Body.Close()
+	if resp.StatusCode == 404 {
+		return nil, nil
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("failed to delete endpoint: %w", newAPIError(resp))
+	}
+
+	var out EndpointResponse
+	if err := decodeResponse(resp, &out); err != nil {
 		return nil, err
 	}
 
-	return m, nil
+	return &out, nil
 }
 
 var ErrEndpointNotFound = errors.New("branch not found")
 
-func (c *Client) GetEndpoint(ctx context.Context, k8sClient client.Client, e *neontechv1alpha1.Endpoint) (map[string]any, error) {
+func (c *Client) GetEndpoint(ctx context.Context, k8sClient client.Client, e *neontechv1alpha1.Endpoint) (*EndpointResponse, error) {
 	if e.Status.Id == "" {
 		return nil, ErrEndpointNotFound
 	}
@@ -178,15 +185,8 @@ func (c *Client) GetEndpoint(ctx context.Context, k8sClient client.Client, e *ne
 		return nil, err
 	}
 
-	url := fmt.Sprintf("https://console.neon.tech/api/v2/projects/%s/endpoints/%s", projectId, e.Status.Id)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Add("Authorization", "Bearer "+c.apiKey)
-	req.Header.Add("Accept", "application/json")
-	resp, err := http.DefaultClient.Do(req)
+	path := fmt.Sprintf("/projects/%s/endpoints/%s", projectId, e.Status.Id)
+	resp, err := c.do(ctx, http.MethodGet, path, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -196,19 +196,13 @@ func (c *Client) GetEndpoint(ctx context.Context, k8sClient client.Client, e *ne
 		if resp.StatusCode == 404 {
 			return nil, ErrEndpointNotFound
 		}
-		return nil, fmt.Errorf("failed to get endpoint: %s", resp.Status)
+		return nil, fmt.Errorf("failed to get endpoint: %w", newAPIError(resp))
 	}
 
-	bytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	m := make(map[string]any)
-	err = json.Unmarshal(bytes, &m)
-	if err != nil {
+	var out EndpointResponse
+	if err := decodeResponse(resp, &out); err != nil {
 		return nil, err
 	}
 
-	return m, nil
+	return &out, nil
 }
@@ -0,0 +1,141 @@
+package neon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/evanshortiss/neon-kube-operator/neon/redact"
+)
+
+// requestIdHeader is the response header Neon uses to identify a request
+// when contacting support.
+const requestIdHeader = "X-Neon-Ret-Request-Id"
+
+// maxErrorBodySize bounds how much of an error response is read.
+const maxErrorBodySize = 64 << 10
+
+// APIError is returned when the Neon API responds with an unexpected status.
+// It carries the details from the error body, and can be inspected with
+// errors.As or the Is* helpers.
+type APIError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// Code is the machine readable error code set by Neon, if any.
+	Code string
+	// Message is the human readable explanation set by Neon. It falls back
+	// to the HTTP status text when the body has no message.
+	Message string
+	// RequestId identifies the request in Neon's logs.
+	RequestId string
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("neon api returned %d", e.StatusCode)
+	if e.Code != "" {
+		msg += " " + e.Code
+	}
+	msg += ": " + e.Message
+	if e.RequestId != "" {
+		msg += " (request id " + e.RequestId + ")"
+	}
+	return msg
+}
+
+// newAPIError builds an APIError from resp, reading its body.
+func newAPIError(resp *http.Response) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		RequestId:  resp.Header.Get(requestIdHeader),
+	}
+
+	var body struct {
+		Code      string `json:"code"`
+		Message   string `json:"message"`
+		RequestId string `json:"request_id"`
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	if err == nil && json.Unmarshal(data, &body) == nil {
+		apiErr.Code = body.Code
+		apiErr.Message = body.Message
+		if apiErr.RequestId == "" {
+			apiErr.RequestId = body.RequestId
+		}
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
+
+func hasStatus(err error, code int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == code
+}
+
+// IsNotFound reports whether err is an APIError for a missing resource.
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsLocked reports whether err is an APIError returned because the project
+// has an operation in progress.
+func IsLocked(err error) bool {
+	return hasStatus(err, http.StatusLocked)
+}
+
+// IsRateLimited reports whether err is an APIError returned because too many
+// requests were made.
+func IsRateLimited(err error) bool {
+	return hasStatus(err, http.StatusTooManyRequests)
+}
+
+// IsUnauthorized reports whether err is an APIError returned because the API
+// key was missing or rejected.
+func IsUnauthorized(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized) || hasStatus(err, http.StatusForbidden)
+}
+
+// IsConflict reports whether err is an APIError returned because the request
+// conflicts with the current state of a resource.
+func IsConflict(err error) bool {
+	return hasStatus(err, http.StatusConflict)
+}
+
+// rateLimitWaitError is returned when a request could not get a token from
+// the client side rate limiter before its context ended.
+type rateLimitWaitError struct {
+	err error
+}
+
+func (e *rateLimitWaitError) Error() string {
+	return "waiting for the rate limiter: " + e.err.Error()
+}
+
+func (e *rateLimitWaitError) Unwrap() error {
+	return e.err
+}
+
+// IsNotSent reports whether err was returned before the request was sent to
+// Neon, because the client has no API key, the circuit breaker is open or the
+// rate limiter did not let the request through. Neon cannot have acted on
+// such a request.
+func IsNotSent(err error) bool {
+	var waitErr *rateLimitWaitError
+	return errors.Is(err, ErrNoAPIKey) || errors.Is(err, ErrCircuitOpen) || errors.As(err, &waitErr)
+}
+
+// ErrorMessage returns the human readable message for err, with credentials
+// masked so that it can be written to a resource status. When err wraps an
+// APIError, the API error's text is replaced by the explanation sent by Neon,
+// keeping any context added by the wrapping errors.
+func ErrorMessage(err error) string {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return redact.String(strings.TrimSuffix(err.Error(), apiErr.Error()) + apiErr.Message)
+	}
+	return redact.String(err.Error())
+}
@@ -0,0 +1,21 @@
+package neon
+
+import "time"
+
+// Limiters returns the number of API key and project limiters l holds.
+func (l *RateLimiter) Limiters() (keys, projects int) {
+	return l.keys.len(), l.projects.len()
+}
+
+// PruneLimiters drops the limiters of l whose bucket is full, as is done
+// periodically.
+func (l *RateLimiter) PruneLimiters() {
+	now := time.Now()
+	for _, s := range []*limiterSet{l.keys, l.projects} {
+		if s != nil {
+			s.mu.Lock()
+			s.prune(now)
+			s.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package neon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// listPageSize is the number of items requested per page.
+const listPageSize = 100
+
+// pagination is the cursor Neon returns with paginated lists.
+type pagination struct {
+	Cursor string `json:"cursor"`
+}
+
+// Iterator walks a list returned by the Neon API, fetching further pages as
+// needed. Call Next until it returns false, then check Err.
+//
+//	it := client.Branches(projectId)
+//	for it.Next(ctx) {
+//		branch := it.Item()
+//	}
+//	if err := it.Err(); err != nil {
+//		return err
+//	}
+type Iterator[T any] struct {
+	fetch func(ctx context.Context, cursor string) ([]T, string, error)
+
+	items  []T
+	item   T
+	cursor string
+	last   bool
+	err    error
+}
+
+// Next advances to the next item. It returns false when the list is
+// exhausted or a page could not be fetched.
+func (it *Iterator[T]) Next(ctx context.Context) bool {
+	for len(it.items) == 0 {
+		if it.last || it.err != nil {
+			return false
+		}
+		items, cursor, err := it.fetch(ctx, it.cursor)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		// The last page is short or comes without a new cursor.
+		it.last = len(items) < listPageSize || cursor == "" || cursor == it.cursor
+		it.items, it.cursor = items, cursor
+	}
+	it.item, it.items = it.items[0], it.items[1:]
+	return true
+}
+
+// Item returns the current item.
+func (it *Iterator[T]) Item() T {
+	return it.item
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+// All returns the remaining items.
+func (it *Iterator[T]) All(ctx context.Context) ([]T, error) {
+	var all []T
+	for it.Next(ctx) {
+		all = append(all, it.Item())
+	}
+	return all, it.Err()
+}
+
+// newIterator returns an iterator over the list at path, whose items are
+// found under key in the response.
+func newIterator[T any, PT interface {
+	*T
+	validator
+}](c *Client, path, key string) *Iterator[T] {
+	return &Iterator[T]{
+		fetch: func(ctx context.Context, cursor string) ([]T, string, error) {
+			return listPage[T, PT](ctx, c, path, key, cursor)
+		},
+	}
+}
+
+func listPage[T any, PT interface {
+	*T
+	validator
+}](ctx context.Context, c *Client, path, key, cursor string) ([]T, string, error) {
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(listPageSize))
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, "", fmt.Errorf("failed to list %s: %w", key, newAPIError(resp))
+	}
+
+	var out listResponse[T, PT]
+	out.key = key
+	if err := decodeResponse(resp, &out); err != nil {
+		return nil, "", err
+	}
+	return out.items, out.pagination.Cursor, nil
+}
+
+// listResponse decodes a page whose items are found under key.
+type listResponse[T any, PT interface {
+	*T
+	validator
+}] struct {
+	key        string
+	items      []T
+	pagination pagination
+}
+
+func (r *listResponse[T, PT]) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	items, ok := raw[r.key]
+	if !ok {
+		return fmt.Errorf("response is missing required field %q", r.key)
+	}
+	if err := json.Unmarshal(items, &r.items); err != nil {
+		return err
+	}
+	if p, ok := raw["pagination"]; ok {
+		if err := json.Unmarshal(p, &r.pagination); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *listResponse[T, PT]) validate() error {
+	for i := range r.items {
+		if err := PT(&r.items[i]).validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Projects returns an iterator over the projects of the account.
+func (c *Client) Projects() *Iterator[Project] {
+	return newIterator[Project](c, "/projects", "projects")
+}
+
+func (c *Client) ListProjects(ctx context.Context) ([]Project, error) {
+	return c.Projects().All(ctx)
+}
+
+// Branches returns an iterator over the branches of a project.
+func (c *Client) Branches(projectId string) *Iterator[Branch] {
+	return newIterator[Branch](c, fmt.Sprintf("/projects/%s/branches", projectId), "branches")
+}
+
+func (c *Client) ListBranches(ctx context.Context, projectId string) ([]Branch, error) {
+	return c.Branches(projectId).All(ctx)
+}
+
+// Endpoints returns an iterator over the endpoints of a project.
+func (c *Client) Endpoints(projectId string) *Iterator[Endpoint] {
+	return newIterator[Endpoint](c, fmt.Sprintf("/projects/%s/endpoints", projectId), "endpoints")
+}
+
+func (c *Client) ListEndpoints(ctx context.Context, projectId string) ([]Endpoint, error) {
+	return c.Endpoints(projectId).All(ctx)
+}
+
+// Databases returns an iterator over the databases of a branch.
+func (c *Client) Databases(projectId, branchId string) *Iterator[Database] {
+	return newIterator[Database](c, fmt.Sprintf("/projects/%s/branches/%s/databases", projectId, branchId), "databases")
+}
+
+func (c *Client) ListDatabases(ctx context.Context, projectId, branchId string) ([]Database, error) {
+	return c.Databases(projectId, branchId).All(ctx)
+}
+
+// Operations returns an iterator over the operations of a project, newest
+// first.
+func (c *Client) Operations(projectId string) *Iterator[Operation] {
+	return newIterator[Operation](c, fmt.Sprintf("/projects/%s/operations", projectId), "operations")
+}
+
+func (c *Client) ListOperations(ctx context.Context, projectId string) ([]Operation, error) {
+	return c.Operations(projectId).All(ctx)
+}
@@ -0,0 +1,59 @@
+package neon
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	rateLimitWaitSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "neon",
+		Subsystem: "api",
+		Name:      "rate_limit_wait_seconds",
+		Help:      "Time Neon API requests spent waiting on the client side rate limiter.",
+		Buckets:   []float64{0.001, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"limiter"})
+
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "neon",
+		Subsystem: "api",
+		Name:      "requests_total",
+		Help:      "Neon API requests by method, route template and status code. Every retry attempt is counted.",
+	}, []string{"method", "route", "code"})
+
+	requestDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "neon",
+		Subsystem: "api",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of Neon API requests by method and route template, excluding rate limiter waits.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"method", "route"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(rateLimitWaitSeconds, requestsTotal, requestDurationSeconds)
+}
+
+// metricsTransport counts and times every request sent to the Neon API.
+// Requests that fail without a response are counted with the code "error".
+type metricsTransport struct {
+	next http.RoundTripper
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	route := parseRoute(req.URL.Path).template
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	requestDurationSeconds.WithLabelValues(req.Method, route).Observe(time.Since(start).Seconds())
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	requestsTotal.WithLabelValues(req.Method, route, code).Inc()
+	return resp, err
+}
@@ -0,0 +1,379 @@
+package neon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// validator is implemented by every response model. It reports fields the
+// operator depends on that are missing from a decoded payload, so that a
+// renamed or removed field in the Neon API surfaces as an error instead of
+// an empty value.
+type validator interface {
+	validate() error
+}
+
+// decodeResponse reads the body of resp into v and validates the result.
+func decodeResponse(resp *http.Response, v validator) error {
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to decode %T: %w", v, err)
+	}
+	if err := v.validate(); err != nil {
+		return fmt.Errorf("failed to decode %T: %w", v, err)
+	}
+	return nil
+}
+
+// requireFields returns an error naming the first empty value in fields.
+// fields is a list of alternating field names and values.
+func requireFields(kind string, fields ...string) error {
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i+1] == "" {
+			return fmt.Errorf("%s is missing required field %q", kind, fields[i])
+		}
+	}
+	return nil
+}
+
+// Project is a Neon project as returned by the API.
+type Project struct {
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	RegionId  string `json:"region_id"`
+	PgVersion int    `json:"pg_version"`
+	// HistoryRetentionSeconds is how long the history of the project is
+	// kept.
+	HistoryRetentionSeconds int64  `json:"history_retention_seconds,omitempty"`
+	CreatedAt               string `json:"created_at"`
+	UpdatedAt               string `json:"updated_at"`
+}
+
+func (p *Project) validate() error {
+	return requireFields("project",
+		"id", p.Id,
+		"region_id", p.RegionId,
+		"created_at", p.CreatedAt,
+	)
+}
+
+// Branch is a Neon branch as returned by the API.
+type Branch struct {
+	Id                 string  `json:"id"`
+	ProjectId          string  `json:"project_id"`
+	ParentId           string  `json:"parent_id,omitempty"`
+	ParentLsn          string  `json:"parent_lsn,omitempty"`
+	ParentTimestamp    string  `json:"parent_timestamp,omitempty"`
+	Name               string  `json:"name"`
+	CurrentState       string  `json:"current_state"`
+	PendingState       string  `json:"pending_state,omitempty"`
+	LogicalSize        int64   `json:"logical_size,omitempty"`
+	CreationSource     string  `json:"creation_source,omitempty"`
+	Primary            bool    `json:"primary"`
+	Default            bool    `json:"default,omitempty"`
+	Protected          bool    `json:"protected,omitempty"`
+	CpuUsedSec         float64 `json:"cpu_used_sec,omitempty"`
+	ComputeTimeSeconds int64   `json:"compute_time_seconds,omitempty"`
+	ActiveTimeSeconds  int64   `json:"active_time_seconds,omitempty"`
+	WrittenDataBytes   int64   `json:"written_data_bytes,omitempty"`
+	DataTransferBytes  int64   `json:"data_transfer_bytes,omitempty"`
+	LastResetAt        string  `json:"last_reset_at,omitempty"`
+	CreatedAt          string  `json:"created_at"`
+	UpdatedAt          string  `json:"updated_at"`
+}
+
+func (b *Branch) validate() error {
+	return requireFields("branch",
+		"id", b.Id,
+		"project_id", b.ProjectId,
+		"name", b.Name,
+		"created_at", b.CreatedAt,
+	)
+}
+
+// EndpointSettings holds the settings of a compute endpoint.
+type EndpointSettings struct {
+	PgSettings map[string]string `json:"pg_settings,omitempty"`
+}
+
+// Endpoint is a Neon compute endpoint as returned by the API.
+type Endpoint struct {
+	Id                    string            `json:"id"`
+	Host                  string            `json:"host"`
+	ProxyHost             string            `json:"proxy_host,omitempty"`
+	ProjectId             string            `json:"project_id"`
+	BranchId              string            `json:"branch_id"`
+	RegionId              string            `json:"region_id,omitempty"`
+	Type                  string            `json:"type"`
+	CurrentState          string            `json:"current_state"`
+	PendingState          string            `json:"pending_state,omitempty"`
+	Settings              *EndpointSettings `json:"settings,omitempty"`
+	AutoscalingLimitMinCu float64           `json:"autoscaling_limit_min_cu,omitempty"`
+	AutoscalingLimitMaxCu float64           `json:"autoscaling_limit_max_cu,omitempty"`
+	Provisioner           string            `json:"provisioner,omitempty"`
+	PoolerEnabled         bool              `json:"pooler_enabled,omitempty"`
+	PoolerMode            string            `json:"pooler_mode,omitempty"`
+	Disabled              bool              `json:"disabled,omitempty"`
+	PasswordlessAccess    bool              `json:"passwordless_access,omitempty"`
+	SuspendTimeoutSeconds int64             `json:"suspend_timeout_seconds,omitempty"`
+	CreationSource        string            `json:"creation_source,omitempty"`
+	LastActive            string            `json:"last_active,omitempty"`
+	CreatedAt             string            `json:"created_at"`
+	UpdatedAt             string            `json:"updated_at"`
+}
+
+func (e *Endpoint) validate() error {
+	return requireFields("endpoint",
+		"id", e.Id,
+		"host", e.Host,
+		"project_id", e.ProjectId,
+		"branch_id", e.BranchId,
+		"current_state", e.CurrentState,
+	)
+}
+
+// Role is a Postgres role on a Neon branch.
+type Role struct {
+	BranchId  string `json:"branch_id"`
+	Name      string `json:"name"`
+	Password  string `json:"password,omitempty"`
+	Protected bool   `json:"protected,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+func (r *Role) validate() error {
+	return requireFields("role",
+		"branch_id", r.BranchId,
+		"name", r.Name,
+	)
+}
+
+// Database is a Postgres database on a Neon branch.
+type Database struct {
+	Id        int64  `json:"id"`
+	BranchId  string `json:"branch_id"`
+	Name      string `json:"name"`
+	OwnerName string `json:"owner_name"`
+	CreatedAt string `json:"created_at,omitempty"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+func (d *Database) validate() error {
+	return requireFields("database",
+		"branch_id", d.BranchId,
+		"name", d.Name,
+		"owner_name", d.OwnerName,
+	)
+}
+
+// Operation is an asynchronous action Neon performs for a project, such as
+// starting a compute or creating a timeline.
+type Operation struct {
+	Id              string `json:"id"`
+	ProjectId       string `json:"project_id"`
+	BranchId        string `json:"branch_id,omitempty"`
+	EndpointId      string `json:"endpoint_id,omitempty"`
+	Action          string `json:"action"`
+	Status          string `json:"status"`
+	Error           string `json:"error,omitempty"`
+	FailuresCount   int    `json:"failures_count"`
+	RetryAt         string `json:"retry_at,omitempty"`
+	TotalDurationMs int64  `json:"total_duration_ms"`
+	CreatedAt       string `json:"created_at"`
+	UpdatedAt       string `json:"updated_at"`
+}
+
+func (o *Operation) validate() error {
+	return requireFields("operation",
+		"id", o.Id,
+		"project_id", o.ProjectId,
+		"action", o.Action,
+		"status", o.Status,
+	)
+}
+
+// ConnectionURI is a connection string returned when a branch is created.
+type ConnectionURI struct {
+	ConnectionURI string `json:"connection_uri"`
+}
+
+// ProjectResponse is the body returned by the project endpoints. Only
+// Project is set by every call; the remaining fields are populated when
+// creating a project.
+type ProjectResponse struct {
+	Project        Project         `json:"project"`
+	Branch         *Branch         `json:"branch,omitempty"`
+	Endpoints      []Endpoint      `json:"endpoints,omitempty"`
+	Operations     []Operation     `json:"operations,omitempty"`
+	Roles          []Role          `json:"roles,omitempty"`
+	Databases      []Database      `json:"databases,omitempty"`
+	ConnectionURIs []ConnectionURI `json:"connection_uris,omitempty"`
+}
+
+func (r *ProjectResponse) validate() error {
+	if err := r.Project.validate(); err != nil {
+		return err
+	}
+	if r.Branch != nil {
+		if err := r.Branch.validate(); err != nil {
+			return err
+		}
+	}
+	for i := range r.Endpoints {
+		if err := r.Endpoints[i].validate(); err != nil {
+			return err
+		}
+	}
+	for i := range r.Operations {
+		if err := r.Operations[i].validate(); err != nil {
+			return err
+		}
+	}
+	for i := range r.Roles {
+		if err := r.Roles[i].validate(); err != nil {
+			return err
+		}
+	}
+	for i := range r.Databases {
+		if err := r.Databases[i].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BranchResponse is the body returned by the branch endpoints. Only Branch
+// is set by every call; the remaining fields are populated when creating or
+// deleting a branch.
+type BranchResponse struct {
+	Branch         Branch          `json:"branch"`
+	Endpoints      []Endpoint      `json:"endpoints,omitempty"`
+	Operations     []Operation     `json:"operations,omitempty"`
+	Roles          []Role          `json:"roles,omitempty"`
+	Databases      []Database      `json:"databases,omitempty"`
+	ConnectionURIs []ConnectionURI `json:"connection_uris,omitempty"`
+}
+
+func (r *BranchResponse) validate() error {
+	if err := r.Branch.validate(); err != nil {
+		return err
+	}
+	for i := range r.Endpoints {
+		if err := r.Endpoints[i].validate(); err != nil {
+			return err
+		}
+	}
+	for i := range r.Operations {
+		if err := r.Operations[i].validate(); err != nil {
+			return err
+		}
+	}
+	for i := range r.Roles {
+		if err := r.Roles[i].validate(); err != nil {
+			return err
+		}
+	}
+	for i := range r.Databases {
+		if err := r.Databases[i].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RoleResponse is the body returned by the role endpoints. Role holds the
+// password when creating a role or resetting its password. Operations is
+// populated by calls that change the role.
+type RoleResponse struct {
+	Role       Role        `json:"role"`
+	Operations []Operation `json:"operations,omitempty"`
+}
+
+func (r *RoleResponse) validate() error {
+	if err := r.Role.validate(); err != nil {
+		return err
+	}
+	for i := range r.Operations {
+		if err := r.Operations[i].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DatabaseResponse is the body returned by the database endpoints.
+// Operations is populated when creating, updating or deleting a database.
+type DatabaseResponse struct {
+	Database   Database    `json:"database"`
+	Operations []Operation `json:"operations,omitempty"`
+}
+
+func (r *DatabaseResponse) validate() error {
+	if err := r.Database.validate(); err != nil {
+		return err
+	}
+	for i := range r.Operations {
+		if err := r.Operations[i].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EndpointResponse is the body returned by the endpoint endpoints.
+// Operations is populated when creating or deleting an endpoint.
+type EndpointResponse struct {
+	Endpoint   Endpoint    `json:"endpoint"`
+	Operations []Operation `json:"operations,omitempty"`
+}
+
+func (r *EndpointResponse) validate() error {
+	if err := r.Endpoint.validate(); err != nil {
+		return err
+	}
+	for i := range r.Operations {
+		if err := r.Operations[i].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type rolesResponse struct {
+	Roles *[]Role `json:"roles"`
+}
+
+func (r *rolesResponse) validate() error {
+	if r.Roles == nil {
+		return fmt.Errorf("response is missing required field %q", "roles")
+	}
+	for i := range *r.Roles {
+		if err := (*r.Roles)[i].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type rolePasswordResponse struct {
+	Password string `json:"password"`
+}
+
+func (r *rolePasswordResponse) validate() error {
+	return requireFields("role password", "password", r.Password)
+}
+
+// OperationResponse is the body returned when reading a single operation.
+type OperationResponse struct {
+	Operation Operation `json:"operation"`
+}
+
+func (r *OperationResponse) validate() error {
+	return r.Operation.validate()
+}
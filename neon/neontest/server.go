@@ -0,0 +1,268 @@
+// Package neontest provides an in-memory implementation of the Neon API for
+// tests. It emulates projects, branches, endpoints, roles, databases and
+// operations closely enough to drive the neon client and the reconcilers
+// without network access.
+package neontest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evanshortiss/neon-kube-operator/neon"
+)
+
+// apiPrefix is the path the fake API is served under, matching the real
+// Neon API.
+const apiPrefix = "/api/v2"
+
+// Server is a fake Neon API backed by an httptest.Server.
+type Server struct {
+	*httptest.Server
+
+	apiKey            string
+	latency           time.Duration
+	operationDuration time.Duration
+
+	mu         sync.Mutex
+	nextId     int
+	projects   map[string]*project
+	operations map[string]*operation
+	requests   []Request
+	failures   []*Failure
+}
+
+// Option configures a Server created by NewServer.
+type Option func(*Server)
+
+// WithAPIKey makes the server reject requests that do not carry apiKey as
+// their bearer token.
+func WithAPIKey(apiKey string) Option {
+	return func(s *Server) {
+		s.apiKey = apiKey
+	}
+}
+
+// WithLatency delays every response by d.
+func WithLatency(d time.Duration) Option {
+	return func(s *Server) {
+		s.latency = d
+	}
+}
+
+// WithOperationDuration sets how long operations stay running before they
+// report finished. By default operations finish immediately.
+func WithOperationDuration(d time.Duration) Option {
+	return func(s *Server) {
+		s.operationDuration = d
+	}
+}
+
+// NewServer starts a fake Neon API. It is closed when the test finishes.
+func NewServer(t testing.TB, opts ...Option) *Server {
+	s := &Server{
+		projects:   make(map[string]*project),
+		operations: make(map[string]*operation),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// BaseURL returns the URL to pass to neon.WithBaseURL.
+func (s *Server) BaseURL() string {
+	return s.URL + apiPrefix
+}
+
+// Client returns a neon.Client talking to the server, with client side rate
+// limiting disabled. opts are applied after the defaults.
+func (s *Server) Client(opts ...neon.ClientOption) *neon.Client {
+	defaults := []neon.ClientOption{
+		neon.WithBaseURL(s.BaseURL()),
+		neon.WithHTTPClient(s.Server.Client()),
+		neon.WithRateLimit(neon.RateLimit{}),
+		neon.WithRetryPolicy(neon.RetryPolicy{
+			MaxAttempts: neon.DefaultRetryPolicy.MaxAttempts,
+			BaseDelay:   time.Millisecond,
+			MaxDelay:    10 * time.Millisecond,
+		}),
+	}
+	apiKey := s.apiKey
+	if apiKey == "" {
+		// The server accepts any key, but the client needs one.
+		apiKey = "neontest"
+	}
+	return neon.CreateClient(apiKey, append(defaults, opts...)...)
+}
+
+// Request is a request received by the server.
+type Request struct {
+	Method string
+	// Path is relative to the API base, e.g. /projects/p-1/branches.
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// Failure describes an error response the server returns instead of
+// handling matching requests.
+type Failure struct {
+	// Method matches the request method. Empty matches any method.
+	Method string
+	// Path is a path.Match pattern for the path relative to the API base,
+	// e.g. /projects/*/branches. Empty matches any path.
+	Path string
+	// StatusCode, Code and Message make up the error response.
+	StatusCode int
+	Code       string
+	Message    string
+	// RetryAfter, if set, is sent in the Retry-After header.
+	RetryAfter time.Duration
+	// Times is the number of requests the failure applies to. Zero means
+	// until ClearFailures is called.
+	Times int
+}
+
+func (f *Failure) matches(method, p string) bool {
+	if f.Method != "" && f.Method != method {
+		return false
+	}
+	if f.Path == "" {
+		return true
+	}
+	ok, _ := path.Match(f.Path, p)
+	return ok
+}
+
+// InjectFailure makes the server answer matching requests with an error.
+func (s *Server) InjectFailure(f Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, &f)
+}
+
+// ClearFailures removes all injected failures.
+func (s *Server) ClearFailures() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = nil
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// RequestCount returns the number of received requests with the given
+// method whose path matches pattern (see Failure.Path). An empty method
+// matches any method.
+func (s *Server) RequestCount(method, pattern string) int {
+	f := Failure{Method: method, Path: pattern}
+	n := 0
+	for _, r := range s.Requests() {
+		if f.matches(r.Method, r.Path) {
+			n++
+		}
+	}
+	return n
+}
+
+// ExpectRequests fails the test unless exactly n requests matching method
+// and pattern were received.
+func (s *Server) ExpectRequests(t testing.TB, method, pattern string, n int) {
+	t.Helper()
+	if got := s.RequestCount(method, pattern); got != n {
+		t.Errorf("expected %d %s %s requests, got %d", n, method, pattern, got)
+	}
+}
+
+// ResetRequests forgets the requests received so far.
+func (s *Server) ResetRequests() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = nil
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.latency > 0 {
+		select {
+		case <-time.After(s.latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	p := strings.TrimPrefix(r.URL.Path, apiPrefix)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, Request{
+		Method: r.Method,
+		Path:   p,
+		Header: r.Header.Clone(),
+		Body:   body,
+	})
+
+	if s.apiKey != "" && r.Header.Get("Authorization") != "Bearer "+s.apiKey {
+		writeError(w, http.StatusUnauthorized, "", "authentication required")
+		return
+	}
+
+	for i, f := range s.failures {
+		if !f.matches(r.Method, p) {
+			continue
+		}
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.failures = append(s.failures[:i], s.failures[i+1:]...)
+			}
+		}
+		if f.RetryAfter > 0 {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(f.RetryAfter.Seconds())))
+		}
+		writeError(w, f.StatusCode, f.Code, f.Message)
+		return
+	}
+
+	s.route(w, r.Method, p, r.URL.Query(), body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		writeError(w, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(buf.Bytes())
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Neon-Ret-Request-Id", "neontest")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"code":    code,
+		"message": message,
+	})
+}
@@ -0,0 +1,839 @@
+package neontest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evanshortiss/neon-kube-operator/neon"
+)
+
+type project struct {
+	neon.Project
+	branches  map[string]*neon.Branch
+	endpoints map[string]*neon.Endpoint
+	// roles and databases are keyed by branch ID, then by name.
+	roles     map[string]map[string]*neon.Role
+	databases map[string]map[string]*neon.Database
+	// pending holds the operation that must finish before a branch or
+	// endpoint leaves its initial state, keyed by resource ID.
+	pending map[string]string
+}
+
+type operation struct {
+	neon.Operation
+	created time.Time
+	// status overrides the computed status when set by SetOperationStatus.
+	status string
+}
+
+func now() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+func (s *Server) newId(prefix string) string {
+	s.nextId++
+	return fmt.Sprintf("%s-test-%06d", prefix, s.nextId)
+}
+
+// AddProject creates a project with a primary branch named "main", an owner
+// role and a "neondb" database, and returns the project ID.
+func (s *Server) AddProject(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addProject(name, "aws-us-east-2", 15).Id
+}
+
+func (s *Server) addProject(name, regionId string, pgVersion int) *project {
+	p := &project{
+		Project: neon.Project{
+			Id:        s.newId("p"),
+			Name:      name,
+			RegionId:  regionId,
+			PgVersion: pgVersion,
+			CreatedAt: now(),
+			UpdatedAt: now(),
+		},
+		branches:  make(map[string]*neon.Branch),
+		endpoints: make(map[string]*neon.Endpoint),
+		roles:     make(map[string]map[string]*neon.Role),
+		databases: make(map[string]map[string]*neon.Database),
+		pending:   make(map[string]string),
+	}
+	s.projects[p.Id] = p
+
+	primary := s.addBranch(p, neon.Branch{Name: "main", Primary: true})
+	s.addRole(p, primary.Id, name+"_owner")
+	s.addDatabase(p, primary.Id, "neondb", name+"_owner")
+	return p
+}
+
+// AddBranch creates a branch from the primary branch of an existing
+// project, as if it was created outside the operator, and returns it.
+func (s *Server) AddBranch(projectId, name string) (neon.Branch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.projects[projectId]
+	if !ok {
+		return neon.Branch{}, fmt.Errorf("project %s not found", projectId)
+	}
+	var parentId string
+	for _, b := range p.branches {
+		if b.Primary {
+			parentId = b.Id
+		}
+	}
+	return *s.addBranch(p, neon.Branch{Name: name, ParentId: parentId}), nil
+}
+
+func (s *Server) addBranch(p *project, b neon.Branch) *neon.Branch {
+	b.Id = s.newId("br")
+	b.ProjectId = p.Id
+	b.CurrentState = "ready"
+	b.CreatedAt = now()
+	b.UpdatedAt = b.CreatedAt
+	p.branches[b.Id] = &b
+	return &b
+}
+
+func (s *Server) addRole(p *project, branchId, name string) *neon.Role {
+	if p.roles[branchId] == nil {
+		p.roles[branchId] = make(map[string]*neon.Role)
+	}
+	r := &neon.Role{
+		BranchId:  branchId,
+		Name:      name,
+		Password:  s.newId("pw"),
+		CreatedAt: now(),
+		UpdatedAt: now(),
+	}
+	p.roles[branchId][name] = r
+	return r
+}
+
+func (s *Server) addDatabase(p *project, branchId, name, owner string) *neon.Database {
+	if p.databases[branchId] == nil {
+		p.databases[branchId] = make(map[string]*neon.Database)
+	}
+	s.nextId++
+	d := &neon.Database{
+		Id:        int64(s.nextId),
+		BranchId:  branchId,
+		Name:      name,
+		OwnerName: owner,
+		CreatedAt: now(),
+		UpdatedAt: now(),
+	}
+	p.databases[branchId][name] = d
+	return d
+}
+
+func (s *Server) addOperation(p *project, action, branchId, endpointId string) neon.Operation {
+	op := &operation{
+		Operation: neon.Operation{
+			Id:         s.newId("op"),
+			ProjectId:  p.Id,
+			BranchId:   branchId,
+			EndpointId: endpointId,
+			Action:     action,
+			CreatedAt:  now(),
+			UpdatedAt:  now(),
+		},
+		created: time.Now(),
+	}
+	s.operations[op.Id] = op
+	return s.currentOperation(op)
+}
+
+// currentOperation returns op with its status as of now.
+func (s *Server) currentOperation(op *operation) neon.Operation {
+	out := op.Operation
+	switch {
+	case op.status != "":
+		out.Status = op.status
+	case time.Since(op.created) >= s.operationDuration:
+		out.Status = neon.OperationStatusFinished
+		out.TotalDurationMs = s.operationDuration.Milliseconds()
+	default:
+		out.Status = neon.OperationStatusRunning
+	}
+	return out
+}
+
+// SetOperationStatus forces the status of an operation, e.g. to
+// neon.OperationStatusFailed.
+func (s *Server) SetOperationStatus(operationId, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if op, ok := s.operations[operationId]; ok {
+		op.status = status
+	}
+}
+
+// Operations returns all operations started on the server.
+func (s *Server) Operations() []neon.Operation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ops []neon.Operation
+	for _, op := range s.operations {
+		ops = append(ops, s.currentOperation(op))
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Id < ops[j].Id })
+	return ops
+}
+
+// pendingDone reports whether the operation guarding resource id finished.
+func (s *Server) pendingDone(p *project, id string) bool {
+	opId, ok := p.pending[id]
+	if !ok {
+		return true
+	}
+	op, ok := s.operations[opId]
+	if !ok {
+		return true
+	}
+	current := s.currentOperation(op)
+	return current.Done()
+}
+
+func (s *Server) branch(p *project, id string) neon.Branch {
+	b := *p.branches[id]
+	if !s.pendingDone(p, id) {
+		b.CurrentState = "init"
+	}
+	return b
+}
+
+func (s *Server) endpoint(p *project, id string) neon.Endpoint {
+	e := *p.endpoints[id]
+	if !s.pendingDone(p, id) {
+		e.CurrentState = "init"
+	}
+	return e
+}
+
+// Branch returns a branch held by the server.
+func (s *Server) Branch(projectId, branchId string) (neon.Branch, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.projects[projectId]
+	if !ok || p.branches[branchId] == nil {
+		return neon.Branch{}, false
+	}
+	return s.branch(p, branchId), true
+}
+
+// Branches returns the branches of a project, ordered by ID.
+func (s *Server) Branches(projectId string) []neon.Branch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listBranches(s.projects[projectId])
+}
+
+func (s *Server) listBranches(p *project) []neon.Branch {
+	if p == nil {
+		return nil
+	}
+	out := []neon.Branch{}
+	for id := range p.branches {
+		out = append(out, s.branch(p, id))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Id < out[j].Id })
+	return out
+}
+
+// Endpoint returns an endpoint held by the server.
+func (s *Server) Endpoint(projectId, endpointId string) (neon.Endpoint, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.projects[projectId]
+	if !ok || p.endpoints[endpointId] == nil {
+		return neon.Endpoint{}, false
+	}
+	return s.endpoint(p, endpointId), true
+}
+
+// Endpoints returns the endpoints of a project, ordered by ID.
+func (s *Server) Endpoints(projectId string) []neon.Endpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listEndpoints(s.projects[projectId])
+}
+
+func (s *Server) listEndpoints(p *project) []neon.Endpoint {
+	if p == nil {
+		return nil
+	}
+	out := []neon.Endpoint{}
+	for id := range p.endpoints {
+		out = append(out, s.endpoint(p, id))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Id < out[j].Id })
+	return out
+}
+
+// Roles returns the roles of a branch, ordered by name.
+func (s *Server) Roles(projectId, branchId string) []neon.Role {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return listRoles(s.projects[projectId], branchId)
+}
+
+func listRoles(p *project, branchId string) []neon.Role {
+	if p == nil {
+		return nil
+	}
+	out := []neon.Role{}
+	for _, r := range p.roles[branchId] {
+		role := *r
+		role.Password = ""
+		out = append(out, role)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Databases returns the databases of a branch, ordered by name.
+func (s *Server) Databases(projectId, branchId string) []neon.Database {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return listDatabases(s.projects[projectId], branchId)
+}
+
+func listDatabases(p *project, branchId string) []neon.Database {
+	if p == nil {
+		return nil
+	}
+	out := []neon.Database{}
+	for _, d := range p.databases[branchId] {
+		out = append(out, *d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// route dispatches a request for path p, relative to the API base. The
+// server lock is held.
+func (s *Server) route(w http.ResponseWriter, method, p string, query url.Values, body []byte) {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	if len(parts) == 0 || parts[0] != "projects" {
+		writeError(w, http.StatusNotFound, "", "not found")
+		return
+	}
+
+	if len(parts) == 1 {
+		switch method {
+		case http.MethodGet:
+			s.handleListProjects(w, query)
+		case http.MethodPost:
+			s.handleCreateProject(w, body)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "", "")
+		}
+		return
+	}
+
+	proj, ok := s.projects[parts[1]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "", "project not found")
+		return
+	}
+	rest := parts[2:]
+
+	switch {
+	case len(rest) == 0:
+		switch method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, map[string]any{"project": proj.Project})
+		case http.MethodDelete:
+			delete(s.projects, proj.Id)
+			writeJSON(w, http.StatusOK, map[string]any{"project": proj.Project})
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "", "")
+		}
+	case rest[0] == "branches":
+		s.routeBranches(w, method, proj, rest[1:], query, body)
+	case rest[0] == "endpoints":
+		s.routeEndpoints(w, method, proj, rest[1:], query, body)
+	case rest[0] == "operations" && len(rest) == 1 && method == http.MethodGet:
+		s.handleListOperations(w, proj, query)
+	case rest[0] == "operations" && len(rest) == 2 && method == http.MethodGet:
+		op, ok := s.operations[rest[1]]
+		if !ok || op.ProjectId != proj.Id {
+			writeError(w, http.StatusNotFound, "", "operation not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"operation": s.currentOperation(op)})
+	default:
+		writeError(w, http.StatusNotFound, "", "not found")
+	}
+}
+
+func (s *Server) handleListProjects(w http.ResponseWriter, query url.Values) {
+	out := []neon.Project{}
+	for _, p := range s.projects {
+		out = append(out, p.Project)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Id < out[j].Id })
+	writeList(w, query, "projects", out, func(p neon.Project) string { return p.Id })
+}
+
+func (s *Server) handleCreateProject(w http.ResponseWriter, body []byte) {
+	var req struct {
+		Project struct {
+			Name                    string `json:"name"`
+			RegionId                string `json:"region_id"`
+			PgVersion               int    `json:"pg_version"`
+			HistoryRetentionSeconds int64  `json:"history_retention_seconds"`
+			DefaultEndpointSettings struct {
+				AutoscalingLimitMinCu float64 `json:"autoscaling_limit_min_cu"`
+				AutoscalingLimitMaxCu float64 `json:"autoscaling_limit_max_cu"`
+				SuspendTimeoutSeconds int64   `json:"suspend_timeout_seconds"`
+			} `json:"default_endpoint_settings"`
+		} `json:"project"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "", err.Error())
+		return
+	}
+	if req.Project.RegionId == "" {
+		req.Project.RegionId = "aws-us-east-2"
+	}
+	if req.Project.PgVersion == 0 {
+		req.Project.PgVersion = 15
+	}
+	if req.Project.HistoryRetentionSeconds == 0 {
+		req.Project.HistoryRetentionSeconds = 86400
+	}
+	p := s.addProject(req.Project.Name, req.Project.RegionId, req.Project.PgVersion)
+	p.HistoryRetentionSeconds = req.Project.HistoryRetentionSeconds
+	var primary neon.Branch
+	for _, b := range p.branches {
+		primary = *b
+	}
+	settings := req.Project.DefaultEndpointSettings
+	endpoint := s.addEndpoint(p, neon.Endpoint{
+		BranchId:              primary.Id,
+		Type:                  "read_write",
+		AutoscalingLimitMinCu: settings.AutoscalingLimitMinCu,
+		AutoscalingLimitMaxCu: settings.AutoscalingLimitMaxCu,
+		SuspendTimeoutSeconds: settings.SuspendTimeoutSeconds,
+	})
+	ops := []neon.Operation{
+		s.addOperation(p, "create_timeline", primary.Id, ""),
+		s.addOperation(p, "start_compute", primary.Id, endpoint.Id),
+	}
+	p.pending[endpoint.Id] = ops[1].Id
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"project":    p.Project,
+		"branch":     primary,
+		"endpoints":  []neon.Endpoint{s.endpoint(p, endpoint.Id)},
+		"roles":      listRoles(p, primary.Id),
+		"databases":  listDatabases(p, primary.Id),
+		"operations": ops,
+	})
+}
+
+func (s *Server) handleListOperations(w http.ResponseWriter, p *project, query url.Values) {
+	out := []neon.Operation{}
+	for _, op := range s.operations {
+		if op.ProjectId == p.Id {
+			out = append(out, s.currentOperation(op))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Id < out[j].Id })
+	writeList(w, query, "operations", out, func(o neon.Operation) string { return o.Id })
+}
+
+func (s *Server) routeBranches(w http.ResponseWriter, method string, p *project, rest []string, query url.Values, body []byte) {
+	if len(rest) == 0 {
+		switch method {
+		case http.MethodGet:
+			writeList(w, query, "branches", s.listBranches(p), func(b neon.Branch) string { return b.Id })
+		case http.MethodPost:
+			s.handleCreateBranch(w, p, body)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "", "")
+		}
+		return
+	}
+
+	b, ok := p.branches[rest[0]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "", "branch not found")
+		return
+	}
+
+	switch {
+	case len(rest) == 1 && method == http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]any{"branch": s.branch(p, b.Id)})
+	case len(rest) == 1 && method == http.MethodDelete:
+		s.handleDeleteBranch(w, p, b)
+	case len(rest) == 2 && rest[1] == "restore" && method == http.MethodPost:
+		s.handleRestoreBranch(w, p, b, body)
+	case len(rest) == 2 && rest[1] == "roles" && method == http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]any{"roles": listRoles(p, b.Id)})
+	case len(rest) == 2 && rest[1] == "roles" && method == http.MethodPost:
+		s.handleCreateRole(w, p, b, body)
+	case len(rest) >= 3 && rest[1] == "roles" && (len(rest) == 3 || rest[3] == "reset_password"):
+		s.routeRole(w, method, p, b, rest[2:])
+	case len(rest) == 4 && rest[1] == "roles" && rest[3] == "reveal_password" && method == http.MethodGet:
+		r, ok := p.roles[b.Id][rest[2]]
+		if !ok {
+			writeError(w, http.StatusNotFound, "", "role not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"password": r.Password})
+	case len(rest) == 2 && rest[1] == "databases" && method == http.MethodGet:
+		writeList(w, query, "databases", listDatabases(p, b.Id), func(d neon.Database) string { return d.Name })
+	case len(rest) == 2 && rest[1] == "databases" && method == http.MethodPost:
+		s.handleCreateDatabase(w, p, b, body)
+	case len(rest) == 3 && rest[1] == "databases":
+		s.routeDatabase(w, method, p, b, rest[2], body)
+	default:
+		writeError(w, http.StatusNotFound, "", "not found")
+	}
+}
+
+func (s *Server) handleCreateBranch(w http.ResponseWriter, p *project, body []byte) {
+	var req struct {
+		Branch struct {
+			Name            string `json:"name"`
+			ParentId        string `json:"parent_id"`
+			ParentLsn       string `json:"parent_lsn"`
+			ParentTimestamp string `json:"parent_timestamp"`
+		} `json:"branch"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "", err.Error())
+		return
+	}
+
+	parent := req.Branch.ParentId
+	if parent == "" {
+		for _, b := range p.branches {
+			if b.Primary {
+				parent = b.Id
+			}
+		}
+	}
+	if _, ok := p.branches[parent]; !ok {
+		writeError(w, http.StatusNotFound, "", "parent branch not found")
+		return
+	}
+
+	b := s.addBranch(p, neon.Branch{
+		Name:            req.Branch.Name,
+		ParentId:        parent,
+		ParentLsn:       req.Branch.ParentLsn,
+		ParentTimestamp: req.Branch.ParentTimestamp,
+	})
+	if b.Name == "" {
+		b.Name = b.Id
+	}
+	// A branch starts with copies of its parent's roles and databases.
+	for name, r := range p.roles[parent] {
+		s.addRole(p, b.Id, name).Password = r.Password
+	}
+	for name, d := range p.databases[parent] {
+		s.addDatabase(p, b.Id, name, d.OwnerName)
+	}
+
+	op := s.addOperation(p, "create_timeline", b.Id, "")
+	p.pending[b.Id] = op.Id
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"branch":     s.branch(p, b.Id),
+		"roles":      listRoles(p, b.Id),
+		"databases":  listDatabases(p, b.Id),
+		"operations": []neon.Operation{op},
+	})
+}
+
+func (s *Server) handleDeleteBranch(w http.ResponseWriter, p *project, b *neon.Branch) {
+	if b.Primary {
+		writeError(w, http.StatusUnprocessableEntity, "", "primary branch cannot be deleted")
+		return
+	}
+	var ops []neon.Operation
+	for id, e := range p.endpoints {
+		if e.BranchId == b.Id {
+			ops = append(ops, s.addOperation(p, "suspend_compute", b.Id, id))
+			delete(p.endpoints, id)
+		}
+	}
+	ops = append(ops, s.addOperation(p, "delete_timeline", b.Id, ""))
+	out := s.branch(p, b.Id)
+	delete(p.branches, b.Id)
+	delete(p.roles, b.Id)
+	delete(p.databases, b.Id)
+	writeJSON(w, http.StatusOK, map[string]any{"branch": out, "operations": ops})
+}
+
+func (s *Server) handleCreateRole(w http.ResponseWriter, p *project, b *neon.Branch, body []byte) {
+	var req struct {
+		Role struct {
+			Name string `json:"name"`
+		} `json:"role"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "", err.Error())
+		return
+	}
+	if _, ok := p.roles[b.Id][req.Role.Name]; ok {
+		writeError(w, http.StatusConflict, "", "role already exists")
+		return
+	}
+	r := s.addRole(p, b.Id, req.Role.Name)
+	op := s.addOperation(p, "apply_config", b.Id, "")
+	writeJSON(w, http.StatusCreated, map[string]any{"role": r, "operations": []neon.Operation{op}})
+}
+
+// routeRole handles the role named rest[0], and its reset_password action
+// if rest has a second segment.
+func (s *Server) routeRole(w http.ResponseWriter, method string, p *project, b *neon.Branch, rest []string) {
+	r, ok := p.roles[b.Id][rest[0]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "", "role not found")
+		return
+	}
+
+	switch {
+	case len(rest) == 2 && method == http.MethodPost:
+		r.Password = s.newId("pw")
+		r.UpdatedAt = now()
+		op := s.addOperation(p, "apply_config", b.Id, "")
+		writeJSON(w, http.StatusOK, map[string]any{"role": r, "operations": []neon.Operation{op}})
+	case len(rest) == 1 && method == http.MethodGet:
+		out := *r
+		out.Password = ""
+		writeJSON(w, http.StatusOK, map[string]any{"role": out})
+	case len(rest) == 1 && method == http.MethodDelete:
+		delete(p.roles[b.Id], r.Name)
+		out := *r
+		out.Password = ""
+		op := s.addOperation(p, "apply_config", b.Id, "")
+		writeJSON(w, http.StatusOK, map[string]any{"role": out, "operations": []neon.Operation{op}})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "", "")
+	}
+}
+
+type databaseRequest struct {
+	Database struct {
+		Name      string `json:"name"`
+		OwnerName string `json:"owner_name"`
+	} `json:"database"`
+}
+
+func (s *Server) handleCreateDatabase(w http.ResponseWriter, p *project, b *neon.Branch, body []byte) {
+	var req databaseRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "", err.Error())
+		return
+	}
+	if _, ok := p.databases[b.Id][req.Database.Name]; ok {
+		writeError(w, http.StatusConflict, "", "database already exists")
+		return
+	}
+	if _, ok := p.roles[b.Id][req.Database.OwnerName]; !ok {
+		writeError(w, http.StatusNotFound, "", "owner role not found")
+		return
+	}
+	d := s.addDatabase(p, b.Id, req.Database.Name, req.Database.OwnerName)
+	op := s.addOperation(p, "apply_config", b.Id, "")
+	writeJSON(w, http.StatusCreated, map[string]any{"database": d, "operations": []neon.Operation{op}})
+}
+
+func (s *Server) routeDatabase(w http.ResponseWriter, method string, p *project, b *neon.Branch, name string, body []byte) {
+	d, ok := p.databases[b.Id][name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "", "database not found")
+		return
+	}
+
+	switch method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]any{"database": d})
+	case http.MethodPatch:
+		var req databaseRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeError(w, http.StatusBadRequest, "", err.Error())
+			return
+		}
+		if owner := req.Database.OwnerName; owner != "" {
+			if _, ok := p.roles[b.Id][owner]; !ok {
+				writeError(w, http.StatusNotFound, "", "owner role not found")
+				return
+			}
+			d.OwnerName = owner
+		}
+		if newName := req.Database.Name; newName != "" && newName != name {
+			if _, ok := p.databases[b.Id][newName]; ok {
+				writeError(w, http.StatusConflict, "", "database already exists")
+				return
+			}
+			delete(p.databases[b.Id], name)
+			d.Name = newName
+			p.databases[b.Id][newName] = d
+		}
+		d.UpdatedAt = now()
+		op := s.addOperation(p, "apply_config", b.Id, "")
+		writeJSON(w, http.StatusOK, map[string]any{"database": d, "operations": []neon.Operation{op}})
+	case http.MethodDelete:
+		delete(p.databases[b.Id], name)
+		op := s.addOperation(p, "apply_config", b.Id, "")
+		writeJSON(w, http.StatusOK, map[string]any{"database": d, "operations": []neon.Operation{op}})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "", "")
+	}
+}
+
+// handleRestoreBranch resets b to its source. Only the bookkeeping is
+// simulated: the branch records when it was reset, and the preserved state
+// becomes a new branch with copies of its roles and databases.
+func (s *Server) handleRestoreBranch(w http.ResponseWriter, p *project, b *neon.Branch, body []byte) {
+	var req struct {
+		SourceBranchId    string `json:"source_branch_id"`
+		SourceLsn         string `json:"source_lsn"`
+		SourceTimestamp   string `json:"source_timestamp"`
+		PreserveUnderName string `json:"preserve_under_name"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "", err.Error())
+		return
+	}
+	if _, ok := p.branches[req.SourceBranchId]; !ok {
+		writeError(w, http.StatusNotFound, "", "source branch not found")
+		return
+	}
+	if req.SourceLsn != "" && req.SourceTimestamp != "" {
+		writeError(w, http.StatusBadRequest, "", "only one of source_lsn and source_timestamp can be set")
+		return
+	}
+	if req.SourceBranchId == b.Id && req.SourceLsn == "" && req.SourceTimestamp == "" {
+		writeError(w, http.StatusBadRequest, "", "a branch can only be restored from itself to a point in time")
+		return
+	}
+	if req.SourceBranchId == b.Id && req.PreserveUnderName == "" {
+		writeError(w, http.StatusBadRequest, "", "restoring a branch from itself requires preserve_under_name")
+		return
+	}
+
+	var ops []neon.Operation
+	if req.PreserveUnderName != "" {
+		preserved := s.addBranch(p, neon.Branch{Name: req.PreserveUnderName, ParentId: b.ParentId})
+		for name, r := range p.roles[b.Id] {
+			s.addRole(p, preserved.Id, name).Password = r.Password
+		}
+		for name, d := range p.databases[b.Id] {
+			s.addDatabase(p, preserved.Id, name, d.OwnerName)
+		}
+		ops = append(ops, s.addOperation(p, "create_timeline", preserved.Id, ""))
+	}
+	b.LastResetAt = now()
+	b.UpdatedAt = b.LastResetAt
+	op := s.addOperation(p, "timeline_restore", b.Id, "")
+	p.pending[b.Id] = op.Id
+	ops = append(ops, op)
+	writeJSON(w, http.StatusOK, map[string]any{"branch": s.branch(p, b.Id), "operations": ops})
+}
+
+func (s *Server) routeEndpoints(w http.ResponseWriter, method string, p *project, rest []string, query url.Values, body []byte) {
+	if len(rest) == 0 {
+		switch method {
+		case http.MethodGet:
+			writeList(w, query, "endpoints", s.listEndpoints(p), func(e neon.Endpoint) string { return e.Id })
+		case http.MethodPost:
+			s.handleCreateEndpoint(w, p, body)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "", "")
+		}
+		return
+	}
+
+	e, ok := p.endpoints[rest[0]]
+	if !ok || len(rest) > 1 {
+		writeError(w, http.StatusNotFound, "", "endpoint not found")
+		return
+	}
+
+	switch method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]any{"endpoint": s.endpoint(p, e.Id)})
+	case http.MethodDelete:
+		op := s.addOperation(p, "suspend_compute", e.BranchId, e.Id)
+		out := s.endpoint(p, e.Id)
+		delete(p.endpoints, e.Id)
+		writeJSON(w, http.StatusOK, map[string]any{"endpoint": out, "operations": []neon.Operation{op}})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "", "")
+	}
+}
+
+func (s *Server) handleCreateEndpoint(w http.ResponseWriter, p *project, body []byte) {
+	var req struct {
+		Endpoint neon.Endpoint `json:"endpoint"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "", err.Error())
+		return
+	}
+	e := req.Endpoint
+	if _, ok := p.branches[e.BranchId]; !ok {
+		writeError(w, http.StatusNotFound, "", "branch not found")
+		return
+	}
+	if e.Type == "read_write" {
+		for _, other := range p.endpoints {
+			if other.BranchId == e.BranchId && other.Type == "read_write" {
+				writeError(w, http.StatusBadRequest, "", "read_write endpoint already exists")
+				return
+			}
+		}
+	}
+
+	e = *s.addEndpoint(p, e)
+
+	op := s.addOperation(p, "start_compute", e.BranchId, e.Id)
+	p.pending[e.Id] = op.Id
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"endpoint":   s.endpoint(p, e.Id),
+		"operations": []neon.Operation{op},
+	})
+}
+
+func (s *Server) addEndpoint(p *project, e neon.Endpoint) *neon.Endpoint {
+	e.Id = s.newId("ep")
+	e.ProjectId = p.Id
+	if e.RegionId == "" {
+		e.RegionId = p.RegionId
+	}
+	e.Host = fmt.Sprintf("%s.%s.aws.neon.tech", e.Id, strings.TrimPrefix(e.RegionId, "aws-"))
+	e.CurrentState = "idle"
+	e.CreatedAt = now()
+	e.UpdatedAt = e.CreatedAt
+	p.endpoints[e.Id] = &e
+	return &e
+}
+
+// writeList writes the page of items selected by the cursor and limit query
+// parameters. items must be sorted by key.
+func writeList[T any](w http.ResponseWriter, query url.Values, name string, items []T, key func(T) string) {
+	if cursor := query.Get("cursor"); cursor != "" {
+		i := sort.Search(len(items), func(i int) bool { return key(items[i]) > cursor })
+		items = items[i:]
+	}
+	if limit, err := strconv.Atoi(query.Get("limit")); err == nil && limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	out := map[string]any{name: items}
+	if len(items) > 0 {
+		out["pagination"] = map[string]string{"cursor": key(items[len(items)-1])}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
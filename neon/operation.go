@@ -0,0 +1,94 @@
+package neon
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Operation statuses reported by Neon.
+const (
+	OperationStatusScheduling = "scheduling"
+	OperationStatusRunning    = "running"
+	OperationStatusFinished   = "finished"
+	OperationStatusFailed     = "failed"
+	OperationStatusError      = "error"
+	OperationStatusCancelling = "cancelling"
+	OperationStatusCancelled  = "cancelled"
+	OperationStatusSkipped    = "skipped"
+)
+
+// Done reports whether the operation completed successfully. Skipped
+// operations are considered done since Neon decided they were not needed.
+func (o *Operation) Done() bool {
+	return o.Status == OperationStatusFinished || o.Status == OperationStatusSkipped
+}
+
+// Failed reports whether the operation ended without completing. Operations
+// in the "error" status are still retried by Neon and are not failed yet.
+func (o *Operation) Failed() bool {
+	return o.Status == OperationStatusFailed || o.Status == OperationStatusCancelled
+}
+
+// OperationFailedError is returned when an operation the operator is waiting
+// on fails in Neon.
+type OperationFailedError struct {
+	Operation Operation
+}
+
+func (e *OperationFailedError) Error() string {
+	if e.Operation.Error != "" {
+		return fmt.Sprintf("operation %s (%s) %s: %s", e.Operation.Id, e.Operation.Action, e.Operation.Status, e.Operation.Error)
+	}
+	return fmt.Sprintf("operation %s (%s) %s", e.Operation.Id, e.Operation.Action, e.Operation.Status)
+}
+
+// OperationIds returns the IDs of ops that have not completed yet.
+func OperationIds(ops []Operation) []string {
+	var ids []string
+	for i := range ops {
+		if !ops[i].Done() {
+			ids = append(ids, ops[i].Id)
+		}
+	}
+	return ids
+}
+
+func (c *Client) GetOperation(ctx context.Context, projectId, operationId string) (*Operation, error) {
+	path := fmt.Sprintf("/projects/%s/operations/%s", projectId, operationId)
+	resp, err := c.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("failed to get operation %s: %w", operationId, newAPIError(resp))
+	}
+	var out OperationResponse
+	if err := decodeResponse(resp, &out); err != nil {
+		return nil, err
+	}
+	return &out.Operation, nil
+}
+
+// PendingOperations polls each of the given operations and returns the IDs
+// of those that have not completed. Failed operations are kept in the
+// returned IDs and reported with an *OperationFailedError, so that callers
+// storing the IDs keep seeing the failure instead of an empty list.
+func (c *Client) PendingOperations(ctx context.Context, projectId string, operationIds []string) ([]string, error) {
+	var pending []string
+	var failed error
+	for i, id := range operationIds {
+		op, err := c.GetOperation(ctx, projectId, id)
+		if err != nil {
+			return append(pending, operationIds[i:]...), err
+		}
+		if op.Failed() && failed == nil {
+			failed = &OperationFailedError{Operation: *op}
+		}
+		if !op.Done() {
+			pending = append(pending, id)
+		}
+	}
+	return pending, failed
+}
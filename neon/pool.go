@@ -0,0 +1,271 @@
+package neon
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	neontechv1alpha1 "github.com/evanshortiss/neon-kube-operator/api/v1alpha1"
+)
+
+// DefaultCredentialsKey is the Secret key read when a CredentialsRef does
+// not name one.
+const DefaultCredentialsKey = "neon-api-key"
+
+// ClientProvider returns the Neon API to use for a resource in namespace
+// that references ref, or the operator's default credentials if ref is nil.
+type ClientProvider interface {
+	ClientFor(ctx context.Context, namespace string, ref *neontechv1alpha1.CredentialsRef) (API, error)
+}
+
+// DefaultClientIdleTimeout is how long a pooled client is kept after it was
+// last handed out.
+const DefaultClientIdleTimeout = 10 * time.Minute
+
+// ClientPool hands out one Neon API per referenced Secret, so that every
+// Neon account gets its own client and cache. A client is replaced
+// when the API key in its Secret changes, and is kept if the Secret is
+// deleted so that resources being deleted along with it can still be
+// finalized.
+//
+// Clients that need to run in the background, such as a Cache, are started
+// once the pool itself is started by the controller manager. Clients that
+// have not been handed out for IdleTimeout are stopped and dropped, and
+// created again from their Secret when they are needed. A client whose
+// Secret is gone is only dropped once no resource with a finalizer
+// references the Secret anymore.
+type ClientPool struct {
+	// IdleTimeout is how long an unused client is kept.
+	IdleTimeout time.Duration
+
+	reader client.Reader
+	newAPI func(apiKey string) API
+
+	mu      sync.Mutex
+	ctx     context.Context
+	clients map[string]*pooledClient
+	def     *pooledClient
+}
+
+type pooledClient struct {
+	secret   types.NamespacedName
+	apiKey   string
+	api      API
+	cancel   context.CancelFunc
+	lastUsed time.Time
+}
+
+var _ ClientProvider = (*ClientPool)(nil)
+
+// NewClientPool returns a pool reading Secrets with reader and creating
+// clients with newAPI. defaultAPI is used for resources without a
+// CredentialsRef and may be nil if the operator has no default credentials.
+func NewClientPool(reader client.Reader, defaultAPI API, newAPI func(apiKey string) API) *ClientPool {
+	p := &ClientPool{
+		IdleTimeout: DefaultClientIdleTimeout,
+		reader:      reader,
+		newAPI:      newAPI,
+		clients:     make(map[string]*pooledClient),
+	}
+	if defaultAPI != nil {
+		p.def = &pooledClient{api: defaultAPI}
+	}
+	return p
+}
+
+// Start starts the background work of pooled clients and stops it when ctx
+// is done. Idle clients are pruned while the pool runs.
+func (p *ClientPool) Start(ctx context.Context) error {
+	p.mu.Lock()
+	p.ctx = ctx
+	if p.def != nil {
+		p.startLocked(p.def)
+	}
+	for _, c := range p.clients {
+		p.startLocked(c)
+	}
+	p.mu.Unlock()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			p.Prune(ctx)
+		}
+	}
+}
+
+// Prune stops and drops the clients that have not been handed out for
+// IdleTimeout. The default client is always kept, and so is a client whose
+// Secret is gone while resources with a finalizer still reference it, since
+// it could not be created again to finalize them.
+func (p *ClientPool) Prune(ctx context.Context) {
+	p.mu.Lock()
+	idle := make(map[string]*pooledClient)
+	for key, c := range p.clients {
+		if time.Since(c.lastUsed) >= p.IdleTimeout {
+			idle[key] = c
+		}
+	}
+	p.mu.Unlock()
+
+	for key, c := range idle {
+		if !p.droppable(ctx, c) {
+			continue
+		}
+		p.mu.Lock()
+		if p.clients[key] == c && time.Since(c.lastUsed) >= p.IdleTimeout {
+			c.stop()
+			delete(p.clients, key)
+		}
+		p.mu.Unlock()
+	}
+}
+
+// droppable reports whether c can be dropped, because its Secret still
+// exists or no resource waiting to be finalized references it.
+func (p *ClientPool) droppable(ctx context.Context, c *pooledClient) bool {
+	err := p.reader.Get(ctx, c.secret, &corev1.Secret{})
+	if err == nil {
+		return true
+	}
+	if !kerrors.IsNotFound(err) {
+		return false
+	}
+	referenced, err := p.referenced(ctx, c.secret)
+	return err == nil && !referenced
+}
+
+// referenced reports whether a resource with a finalizer in the namespace of
+// secret has a CredentialsRef naming it.
+func (p *ClientPool) referenced(ctx context.Context, secret types.NamespacedName) (bool, error) {
+	lists := []client.ObjectList{
+		&neontechv1alpha1.ProjectList{},
+		&neontechv1alpha1.BranchList{},
+		&neontechv1alpha1.EndpointList{},
+		&neontechv1alpha1.DatabaseList{},
+		&neontechv1alpha1.RoleList{},
+	}
+	for _, list := range lists {
+		if err := p.reader.List(ctx, list, client.InNamespace(secret.Namespace)); err != nil {
+			return false, err
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return false, err
+		}
+		for _, item := range items {
+			obj, ok := item.(client.Object)
+			if !ok || len(obj.GetFinalizers()) == 0 {
+				continue
+			}
+			if ref := credentialsRef(obj); ref != nil && ref.Name == secret.Name {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// credentialsRef returns the CredentialsRef of obj, or nil if it has none.
+func credentialsRef(obj client.Object) *neontechv1alpha1.CredentialsRef {
+	switch o := obj.(type) {
+	case *neontechv1alpha1.Project:
+		return o.Spec.CredentialsRef
+	case *neontechv1alpha1.Branch:
+		return o.Spec.CredentialsRef
+	case *neontechv1alpha1.Endpoint:
+		return o.Spec.CredentialsRef
+	case *neontechv1alpha1.Database:
+		return o.Spec.CredentialsRef
+	case *neontechv1alpha1.Role:
+		return o.Spec.CredentialsRef
+	}
+	return nil
+}
+
+// stop stops the background work of c and closes its API, which must no
+// longer be handed out.
+func (c *pooledClient) stop() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	if closer, ok := c.api.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+func (p *ClientPool) startLocked(c *pooledClient) {
+	r, ok := c.api.(interface{ Start(context.Context) error })
+	if !ok || p.ctx == nil || c.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(p.ctx)
+	c.cancel = cancel
+	go func() { _ = r.Start(ctx) }()
+}
+
+func (p *ClientPool) ClientFor(ctx context.Context, namespace string, ref *neontechv1alpha1.CredentialsRef) (API, error) {
+	if ref == nil {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.def == nil {
+			return nil, fmt.Errorf("no credentialsRef is set and the operator has no default Neon API key")
+		}
+		return p.def.api, nil
+	}
+
+	key := ref.Key
+	if key == "" {
+		key = DefaultCredentialsKey
+	}
+	poolKey := namespace + "/" + ref.Name + "/" + key
+
+	secretName := types.NamespacedName{Namespace: namespace, Name: ref.Name}
+	secret := &corev1.Secret{}
+	err := p.reader.Get(ctx, secretName, secret)
+	if err != nil {
+		p.mu.Lock()
+		c, ok := p.clients[poolKey]
+		if ok {
+			c.lastUsed = time.Now()
+		}
+		p.mu.Unlock()
+		if ok {
+			return c.api, nil
+		}
+		if kerrors.IsNotFound(err) {
+			return nil, fmt.Errorf("credentials secret %s is not found, %w", ref.Name, ErrRetryAgain)
+		}
+		return nil, err
+	}
+	apiKey := string(secret.Data[key])
+	if apiKey == "" {
+		return nil, fmt.Errorf("key %s is missing in credentials secret %s", key, ref.Name)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c, ok := p.clients[poolKey]
+	if ok && c.apiKey == apiKey {
+		c.lastUsed = time.Now()
+		return c.api, nil
+	}
+	if ok {
+		c.stop()
+	}
+	c = &pooledClient{secret: secretName, apiKey: apiKey, api: p.newAPI(apiKey), lastUsed: time.Now()}
+	p.clients[poolKey] = c
+	p.startLocked(c)
+	return c.api, nil
+}
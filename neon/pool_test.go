@@ -0,0 +1,123 @@
+package neon_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	neontechv1alpha1 "github.com/evanshortiss/neon-kube-operator/api/v1alpha1"
+	"github.com/evanshortiss/neon-kube-operator/neon"
+)
+
+func TestClientPool(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "team-a", Namespace: "default"},
+		Data:       map[string][]byte{neon.DefaultCredentialsKey: []byte("key-a")},
+	}
+	k8sClient := fake.NewClientBuilder().WithObjects(secret).Build()
+	var created []string
+	pool := neon.NewClientPool(k8sClient, nil, func(apiKey string) neon.API {
+		created = append(created, apiKey)
+		return neon.CreateClient(apiKey)
+	})
+	ctx := context.Background()
+	ref := &neontechv1alpha1.CredentialsRef{Name: "team-a"}
+
+	if _, err := pool.ClientFor(ctx, "default", nil); err == nil {
+		t.Error("expected an error without default credentials")
+	}
+	first, err := pool.ClientFor(ctx, "default", ref)
+	if err != nil {
+		t.Fatalf("ClientFor: %v", err)
+	}
+	if again, _ := pool.ClientFor(ctx, "default", ref); again != first {
+		t.Error("expected the pooled client to be reused")
+	}
+
+	// A rotated key replaces the client.
+	secret.Data[neon.DefaultCredentialsKey] = []byte("key-b")
+	if err := k8sClient.Update(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := pool.ClientFor(ctx, "default", ref)
+	if err != nil || rotated == first {
+		t.Fatalf("expected a new client after rotation, got %v", err)
+	}
+
+	// The last client stays available once the Secret is gone.
+	if err := k8sClient.Delete(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := pool.ClientFor(ctx, "default", ref); err != nil || c != rotated {
+		t.Errorf("expected the pooled client after deletion, got %v", err)
+	}
+	if _, err := pool.ClientFor(ctx, "other", ref); !errors.Is(err, neon.ErrRetryAgain) {
+		t.Errorf("expected ErrRetryAgain for a missing secret, got %v", err)
+	}
+	if len(created) != 2 || created[0] != "key-a" || created[1] != "key-b" {
+		t.Errorf("unexpected clients %v", created)
+	}
+}
+
+// backgroundAPI records the contexts its background work is started with.
+type backgroundAPI struct {
+	neon.API
+	started chan context.Context
+}
+
+func (a *backgroundAPI) Start(ctx context.Context) error {
+	a.started <- ctx
+	<-ctx.Done()
+	return nil
+}
+
+func TestClientPoolStartsAndPrunesClients(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "team-a", Namespace: "default"},
+		Data:       map[string][]byte{neon.DefaultCredentialsKey: []byte("key-a")},
+	}
+	k8sClient := fake.NewClientBuilder().WithObjects(secret).Build()
+	started := make(chan context.Context, 2)
+	pool := neon.NewClientPool(k8sClient, nil, func(apiKey string) neon.API {
+		return &backgroundAPI{API: neon.CreateClient(apiKey), started: started}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = pool.Start(ctx) }()
+	ref := &neontechv1alpha1.CredentialsRef{Name: "team-a"}
+
+	// The pool may not have started yet, its clients are started when it
+	// does.
+	first, err := pool.ClientFor(ctx, "default", ref)
+	if err != nil {
+		t.Fatalf("ClientFor: %v", err)
+	}
+	var background context.Context
+	select {
+	case background = <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pooled client was not started")
+	}
+
+	// A client in use is kept.
+	pool.Prune(ctx)
+	if again, _ := pool.ClientFor(ctx, "default", ref); again != first {
+		t.Error("expected the pooled client to be kept")
+	}
+
+	pool.IdleTimeout = 0
+	pool.Prune(ctx)
+	select {
+	case <-background.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pruned client was not stopped")
+	}
+	if again, err := pool.ClientFor(ctx, "default", ref); err != nil || again == first {
+		t.Errorf("expected a new client after pruning, got %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package neon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	neontechv1alpha1 "github.com/evanshortiss/neon-kube-operator/api/v1alpha1"
+)
+
+var ErrProjectNotFound = errors.New("project not found")
+
+func projectSpecToCreateRequestBody(p *neontechv1alpha1.Project) map[string]any {
+	body := make(map[string]any)
+	projectSpec := p.Spec
+	project := make(map[string]any)
+	project["name"] = p.NeonName()
+
+	if projectSpec.RegionId != "" {
+		project["region_id"] = projectSpec.RegionId
+	}
+
+	if projectSpec.PgVersion != 0 {
+		project["pg_version"] = projectSpec.PgVersion
+	}
+
+	if projectSpec.HistoryRetentionSeconds != nil {
+		project["history_retention_seconds"] = projectSpec.HistoryRetentionSeconds
+	}
+
+	if s := projectSpec.DefaultEndpointSettings; s != nil {
+		settings := make(map[string]any)
+		if s.AutoscalingLimitMinCu != nil {
+			settings["autoscaling_limit_min_cu"] = s.AutoscalingLimitMinCu
+		}
+		if s.AutoscalingLimitMaxCu != nil {
+			settings["autoscaling_limit_max_cu"] = s.AutoscalingLimitMaxCu
+		}
+		if s.SuspendTimeoutSeconds != nil {
+			settings["suspend_timeout_seconds"] = s.SuspendTimeoutSeconds
+		}
+		project["default_endpoint_settings"] = settings
+	}
+
+	body["project"] = project
+
+	return body
+}
+
+func (c *Client) CreateProject(ctx context.Context, project *neontechv1alpha1.Project) (*ProjectResponse, error) {
+	resp, err := c.do(ctx, http.MethodPost, "/projects", projectSpecToCreateRequestBody(project))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != 201 {
+		return nil, fmt.Errorf("failed to create project: %w", newAPIError(resp))
+	}
+
+	var out ProjectResponse
+	if err := decodeResponse(resp, &out); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
+
+func (c *Client) GetProject(ctx context.Context, project *neontechv1alpha1.Project) (*ProjectResponse, error) {
+	if project.Status.Id == "" {
+		return nil, ErrProjectNotFound
+	}
+	path := fmt.Sprintf("/projects/%s", project.Status.Id)
+	resp, err := c.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		if resp.StatusCode == 404 {
+			return nil, ErrProjectNotFound
+		}
+		return nil, fmt.Errorf("failed to get project: %w", newAPIError(resp))
+	}
+	var out ProjectResponse
+	if err := decodeResponse(resp, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteProject deletes the project along with all of its branches and
+// endpoints. It returns a nil response if the project no longer exists in
+// Neon.
+func (c *Client) DeleteProject(ctx context.Context, project *neontechv1alpha1.Project) (*ProjectResponse, error) {
+	path := fmt.Sprintf("/projects/%s", project.Status.Id)
+	resp, err := c.do(ctx, http.MethodDelete, path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode == 404 {
+		return nil, nil
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("failed to delete project: %w", newAPIError(resp))
+	}
+
+	var out ProjectResponse
+	if err := decodeResponse(resp, &out); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
+
+// FindProject returns the project of the account with the name of the
+// Project resource, or ErrProjectNotFound if there is none. Projects whose
+// ids are in owned belong to other resources and are skipped.
+func (c *Client) FindProject(ctx context.Context, project *neontechv1alpha1.Project, owned map[string]bool) (*Project, error) {
+	it := c.Projects()
+	for it.Next(ctx) {
+		if p := it.Item(); p.Name == project.NeonName() && !owned[p.Id] {
+			return &p, nil
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return nil, ErrProjectNotFound
+}
+
+// GetProjectRef returns the Project resource named ref in namespace. It
+// returns an error wrapping ErrRetryAgain until the project exists in Neon.
+func GetProjectRef(ctx context.Context, k8sClient client.Client, namespace, ref string) (*neontechv1alpha1.Project, error) {
+	project := &neontechv1alpha1.Project{}
+	err := k8sClient.Get(ctx, types.NamespacedName{Name: ref, Namespace: namespace}, project)
+	if kerrors.IsNotFound(err) {
+		return nil, fmt.Errorf("project is not found yet, %w", ErrRetryAgain)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !project.Status.State.Exists() {
+		return nil, fmt.Errorf("project status is not updated yet, %w", ErrRetryAgain)
+	}
+	return project, nil
+}
+
+func NewProjectStatus(project Project) neontechv1alpha1.ProjectStatus {
+	return neontechv1alpha1.ProjectStatus{
+		Id:        project.Id,
+		Name:      project.Name,
+		RegionId:  project.RegionId,
+		PgVersion: project.PgVersion,
+		CreatedAt: project.CreatedAt,
+		UpdatedAt: project.UpdatedAt,
+	}
+}
@@ -0,0 +1,185 @@
+package neon
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimit configures the client side token bucket limiters that every
+// request to the Neon API waits on before it is sent.
+type RateLimit struct {
+	// QPS and Burst configure the limiter kept for each API key, shared by
+	// all requests made with it. A QPS of zero disables it.
+	QPS   float64
+	Burst int
+	// ProjectQPS and ProjectBurst configure the limiter kept for each
+	// project. A ProjectQPS of zero disables it.
+	ProjectQPS   float64
+	ProjectBurst int
+}
+
+// DefaultRateLimit is used unless WithRateLimit is set. It keeps the
+// operator below the request rate Neon allows for an account.
+var DefaultRateLimit = RateLimit{
+	QPS:          10,
+	Burst:        20,
+	ProjectQPS:   5,
+	ProjectBurst: 10,
+}
+
+// WithRateLimit sets the rate limits of the client.
+func WithRateLimit(limit RateLimit) ClientOption {
+	return func(c *Client) {
+		c.rateLimit = limit
+	}
+}
+
+// WithRateLimiter makes the client wait on limiter, which may be shared with
+// other clients so that they are limited together. It takes precedence over
+// WithRateLimit. Clients have a limiter of their own unless this option is
+// set.
+func WithRateLimiter(limiter *RateLimiter) ClientOption {
+	return func(c *Client) {
+		c.rateLimiter = limiter
+	}
+}
+
+// rateLimiterPruneInterval is how often limiters with a full bucket are
+// dropped.
+const rateLimiterPruneInterval = time.Minute
+
+// RateLimiter holds the token buckets of a RateLimit. Buckets are kept for
+// each API key and each project seen, and dropped once they are full again,
+// since a new bucket would behave the same.
+type RateLimiter struct {
+	keys     *limiterSet
+	projects *limiterSet
+}
+
+// NewRateLimiter returns a limiter enforcing limit.
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	return &RateLimiter{
+		keys:     newLimiterSet(limit.QPS, limit.Burst),
+		projects: newLimiterSet(limit.ProjectQPS, limit.ProjectBurst),
+	}
+}
+
+func (l *RateLimiter) projectLimiter(projectId string) *rate.Limiter {
+	if projectId == "" {
+		return nil
+	}
+	return l.projects.get(projectId)
+}
+
+// limiterSet holds a token bucket for each key it is asked for.
+type limiterSet struct {
+	limit rate.Limit
+	burst int
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+	pruned   time.Time
+}
+
+func newLimiterSet(qps float64, burst int) *limiterSet {
+	if qps <= 0 {
+		return nil
+	}
+	return &limiterSet{
+		limit:    rate.Limit(qps),
+		burst:    burst,
+		limiters: make(map[string]*rate.Limiter),
+		pruned:   time.Now(),
+	}
+}
+
+// get returns the limiter for key, or nil if the set is disabled.
+func (s *limiterSet) get(key string) *rate.Limiter {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now := time.Now(); now.Sub(s.pruned) >= rateLimiterPruneInterval {
+		s.prune(now)
+	}
+	limiter, ok := s.limiters[key]
+	if !ok {
+		limiter = rate.NewLimiter(s.limit, s.burst)
+		s.limiters[key] = limiter
+	}
+	return limiter
+}
+
+// prune drops the limiters whose bucket is full. s.mu must be held.
+func (s *limiterSet) prune(now time.Time) {
+	for key, limiter := range s.limiters {
+		if limiter.TokensAt(now) >= float64(s.burst) {
+			delete(s.limiters, key)
+		}
+	}
+	s.pruned = now
+}
+
+func (s *limiterSet) len() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.limiters)
+}
+
+type rateLimitTransport struct {
+	next    http.RoundTripper
+	limiter *RateLimiter
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	if limiter := t.limiter.keys.get(apiKeyHash(req)); limiter != nil {
+		start := time.Now()
+		if err := limiter.Wait(ctx); err != nil {
+			return nil, &rateLimitWaitError{err}
+		}
+		rateLimitWaitSeconds.WithLabelValues("api_key").Observe(time.Since(start).Seconds())
+	}
+
+	if limiter := t.limiter.projectLimiter(projectIdFromPath(req.URL.Path)); limiter != nil {
+		start := time.Now()
+		if err := limiter.Wait(ctx); err != nil {
+			return nil, &rateLimitWaitError{err}
+		}
+		rateLimitWaitSeconds.WithLabelValues("project").Observe(time.Since(start).Seconds())
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+// apiKeyHash identifies the API key req is sent with, without keeping the
+// key itself.
+func apiKeyHash(req *http.Request) string {
+	sum := sha256.Sum256([]byte(req.Header.Get("Authorization")))
+	return hex.EncodeToString(sum[:])
+}
+
+// projectIdFromPath returns the project ID of a request path such as
+// /api/v2/projects/{project_id}/branches, or "" if the path is not scoped to
+// a project.
+func projectIdFromPath(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if parts[i] == "projects" {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
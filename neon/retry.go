@@ -0,0 +1,156 @@
+package neon
+
+import (
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RetryPolicy controls how requests that fail with a transient error are
+// retried by the client.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts made for a request,
+	// including the first one. Values below 2 disable retries.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry. It doubles with every
+	// further attempt.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between attempts. A Retry-After header asking
+	// for a longer delay stops the retries.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is used unless WithRetryPolicy is set.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    10 * time.Second,
+}
+
+// WithRetryPolicy sets the retry policy of the client.
+func WithRetryPolicy(policy RetryPolicy) ClientOption {
+	return func(c *Client) {
+		c.retryPolicy = policy
+	}
+}
+
+// retryTransport retries requests rejected with 423 Locked or 429 Too Many
+// Requests, and requests with an idempotent method that failed with a 5xx
+// status or a transport error. Other POST and PATCH failures are not retried
+// since Neon may have acted on the request.
+type retryTransport struct {
+	next   http.RoundTripper
+	policy RetryPolicy
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		r := req
+		if attempt > 1 {
+			r = req.Clone(req.Context())
+			if req.Body != nil && req.Body != http.NoBody {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				r.Body = body
+			}
+		}
+
+		resp, err := t.next.RoundTrip(r)
+		if attempt >= t.policy.MaxAttempts || !shouldRetry(req, resp, err) {
+			return resp, err
+		}
+
+		delay, ok := t.delay(attempt, resp)
+		if !ok {
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBodySize))
+			resp.Body.Close()
+		}
+
+		retryAttrs := []attribute.KeyValue{
+			attribute.Int("attempt", attempt),
+			attribute.Int64("delay_ms", delay.Milliseconds()),
+		}
+		if resp != nil {
+			retryAttrs = append(retryAttrs, semconv.HTTPStatusCodeKey.Int(resp.StatusCode))
+		}
+		trace.SpanFromContext(req.Context()).AddEvent("retry", trace.WithAttributes(retryAttrs...))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// delay returns how long to wait before the next attempt. It returns false
+// when the server asked for a longer wait than the policy allows.
+func (t *retryTransport) delay(attempt int, resp *http.Response) (time.Duration, bool) {
+	if resp != nil {
+		if after, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			return after, after <= t.policy.MaxDelay
+		}
+	}
+
+	delay := t.policy.BaseDelay << (attempt - 1)
+	if delay <= 0 || delay > t.policy.MaxDelay {
+		delay = t.policy.MaxDelay
+	}
+	// Wait between half and the full delay so that reconcilers that failed
+	// together do not retry together.
+	half := int64(delay / 2)
+	return time.Duration(half + rand.Int63n(half+1)), true
+}
+
+func shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		return req.Context().Err() == nil && isIdempotent(req.Method)
+	}
+	switch {
+	case resp.StatusCode == http.StatusLocked, resp.StatusCode == http.StatusTooManyRequests:
+		return true
+	case resp.StatusCode >= 500:
+		return isIdempotent(req.Method)
+	}
+	return false
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as
+// an HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		after := time.Until(at)
+		if after < 0 {
+			after = 0
+		}
+		return after, true
+	}
+	return 0, false
+}
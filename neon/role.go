@@ -2,10 +2,13 @@ package neon
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
+
+	neontechv1alpha1 "github.com/evanshortiss/neon-kube-operator/api/v1alpha1"
+	"github.com/evanshortiss/neon-kube-operator/neon/redact"
 )
 
 func (c *Client) GetFirstRole(ctx context.Context, projectId, branchId string) (string, error) {
@@ -16,83 +19,169 @@ func (c *Client) GetFirstRole(ctx context.Context, projectId, branchId string) (
 	if err != nil {
 		return "", err
 	}
-	rolesObj, ok := roles["roles"].([]any)
-	if !ok {
-		return "", fmt.Errorf("incorrect body roles")
-	}
-	if len(rolesObj) == 0 {
+	if len(roles) == 0 {
 		return "", fmt.Errorf("no role found")
 	}
-	role, ok := rolesObj[0].(map[string]any)
-	if !ok {
-		return "", fmt.Errorf("incorrect body role")
-	}
-	name, ok := role["name"].(string)
-	if !ok {
-		return "", fmt.Errorf("no name of role")
-	}
-	return name, nil
+	return roles[0].Name, nil
 }
 
-func (c *Client) GetRoles(ctx context.Context, projectId, branchId string) (map[string]any, error) {
+func (c *Client) GetRoles(ctx context.Context, projectId, branchId string) ([]Role, error) {
 	if branchId == "" {
 		return nil, ErrBranchNotFound
 	}
-	url := fmt.Sprintf("https://console.neon.tech/api/v2/projects/%s/branches/%s/roles", projectId, branchId)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	path := fmt.Sprintf("/projects/%s/branches/%s/roles", projectId, branchId)
+	resp, err := c.do(ctx, http.MethodGet, path, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Authorization", "Bearer "+c.apiKey)
-	req.Header.Add("Accept", "application/json")
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("failed to get roles: %w", newAPIError(resp))
+	}
+	var out rolesResponse
+	if err := decodeResponse(resp, &out); err != nil {
 		return nil, err
 	}
+	return *out.Roles, nil
+}
+
+func (c *Client) GetRolePassword(ctx context.Context, projectId, branchId, role string) (string, error) {
+	path := fmt.Sprintf("/projects/%s/branches/%s/roles/%s/reveal_password", projectId, branchId, role)
+	resp, err := c.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return "", err
+	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("failed to get roles %s", resp.Status)
+		return "", fmt.Errorf("failed to get role password: %w", newAPIError(resp))
+	}
+	var out rolePasswordResponse
+	if err := decodeResponse(resp, &out); err != nil {
+		return "", err
 	}
-	m := make(map[string]any)
-	bytes, err := io.ReadAll(resp.Body)
+	redact.Register(rolePasswordId(projectId, branchId, role), out.Password)
+	return out.Password, nil
+}
+
+var ErrRoleNotFound = errors.New("role not found")
+
+// rolePath returns the path of the role on the branch the resource resolved
+// to. The role is the one named in the status once it has been created.
+func rolePath(r *neontechv1alpha1.Role) string {
+	return fmt.Sprintf("/projects/%s/branches/%s/roles/%s", r.Status.ProjectId, r.Status.BranchId, url.PathEscape(roleName(r)))
+}
+
+func roleName(r *neontechv1alpha1.Role) string {
+	if r.Status.Name != "" {
+		return r.Status.Name
+	}
+	return r.NeonName()
+}
+
+// rolePasswordId returns the id the password of a role is registered under
+// for redaction, so that it is masked until the role is deleted.
+func rolePasswordId(projectId, branchId, role string) string {
+	return fmt.Sprintf("role password %s/%s/%s", projectId, branchId, role)
+}
+
+// CreateRole creates the role on the branch recorded in the status of the
+// resource. The response holds the password of the new role.
+func (c *Client) CreateRole(ctx context.Context, r *neontechv1alpha1.Role) (*RoleResponse, error) {
+	path := fmt.Sprintf("/projects/%s/branches/%s/roles", r.Status.ProjectId, r.Status.BranchId)
+	body := map[string]any{"role": map[string]any{"name": r.NeonName()}}
+	resp, err := c.do(ctx, http.MethodPost, path, body)
 	if err != nil {
 		return nil, err
 	}
-	err = json.Unmarshal(bytes, &m)
-	if err != nil {
+
+	defer resp.Body.Close()
+	if resp.StatusCode != 201 {
+		return nil, fmt.Errorf("failed to create role: %w", newAPIError(resp))
+	}
+
+	var out RoleResponse
+	if err := decodeResponse(resp, &out); err != nil {
 		return nil, err
 	}
-	return m, nil
+	redact.Register(rolePasswordId(r.Status.ProjectId, r.Status.BranchId, out.Role.Name), out.Role.Password)
+
+	return &out, nil
 }
 
-func (c *Client) GetRolePassword(ctx context.Context, projectId, branchId, role string) (string, error) {
-	url := fmt.Sprintf("https://console.neon.tech/api/v2/projects/%s/branches/%s/roles/%s/reveal_password", projectId, branchId, role)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+// GetRole returns the role of the resource, or ErrRoleNotFound if it does
+// not exist on the branch.
+func (c *Client) GetRole(ctx context.Context, r *neontechv1alpha1.Role) (*RoleResponse, error) {
+	resp, err := c.do(ctx, http.MethodGet, rolePath(r), nil)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		if resp.StatusCode == 404 {
+			return nil, ErrRoleNotFound
+		}
+		return nil, fmt.Errorf("failed to get role: %w", newAPIError(resp))
+	}
+	var out RoleResponse
+	if err := decodeResponse(resp, &out); err != nil {
+		return nil, err
 	}
-	req.Header.Add("Authorization", "Bearer "+c.apiKey)
-	req.Header.Add("Accept", "application/json")
-	resp, err := http.DefaultClient.Do(req)
+	return &out, nil
+}
+
+// DeleteRole deletes the role. It returns a nil response if the role no
+// longer exists in Neon.
+func (c *Client) DeleteRole(ctx context.Context, r *neontechv1alpha1.Role) (*RoleResponse, error) {
+	resp, err := c.do(ctx, http.MethodDelete, rolePath(r), nil)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+
 	defer resp.Body.Close()
+	if resp.StatusCode == 404 {
+		redact.Unregister(rolePasswordId(r.Status.ProjectId, r.Status.BranchId, roleName(r)))
+		return nil, nil
+	}
 	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("failed to get roles %s", resp.Status)
+		return nil, fmt.Errorf("failed to delete role: %w", newAPIError(resp))
 	}
-	m := make(map[string]any)
-	bytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
+	redact.Unregister(rolePasswordId(r.Status.ProjectId, r.Status.BranchId, roleName(r)))
+
+	var out RoleResponse
+	if err := decodeResponse(resp, &out); err != nil {
+		return nil, err
 	}
-	err = json.Unmarshal(bytes, &m)
+
+	return &out, nil
+}
+
+// ResetRolePassword gives the role a new password, which is returned in the
+// response.
+func (c *Client) ResetRolePassword(ctx context.Context, r *neontechv1alpha1.Role) (*RoleResponse, error) {
+	resp, err := c.do(ctx, http.MethodPost, rolePath(r)+"/reset_password", nil)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("failed to reset role password: %w", newAPIError(resp))
+	}
+
+	var out RoleResponse
+	if err := decodeResponse(resp, &out); err != nil {
+		return nil, err
 	}
-	password, ok := m["password"].(string)
-	if !ok {
-		return "", fmt.Errorf("no password in body")
+	redact.Register(rolePasswordId(r.Status.ProjectId, r.Status.BranchId, out.Role.Name), out.Role.Password)
+
+	return &out, nil
+}
+
+func NewRoleStatus(role Role) neontechv1alpha1.RoleStatus {
+	return neontechv1alpha1.RoleStatus{
+		Name:      role.Name,
+		BranchId:  role.BranchId,
+		CreatedAt: role.CreatedAt,
+		UpdatedAt: role.UpdatedAt,
 	}
-	return password, nil
 }
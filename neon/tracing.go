@@ -0,0 +1,129 @@
+package neon
+
+import (
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/evanshortiss/neon-kube-operator/neon/redact"
+)
+
+// tracer uses the global tracer provider, so spans are dropped unless
+// tracing is set up by the operator.
+var tracer = otel.Tracer("github.com/evanshortiss/neon-kube-operator/neon")
+
+// Span attributes identifying the Neon resources a request is about.
+const (
+	ProjectIdKey   = attribute.Key("neon.project_id")
+	BranchIdKey    = attribute.Key("neon.branch_id")
+	EndpointIdKey  = attribute.Key("neon.endpoint_id")
+	OperationIdKey = attribute.Key("neon.operation_id")
+)
+
+// route is a Neon API path split into a template, such as
+// /projects/{project_id}/branches/{branch_id}, and the ids it contains.
+type route struct {
+	template    string
+	projectId   string
+	branchId    string
+	endpointId  string
+	operationId string
+}
+
+// routeParams names the path segment that follows a collection.
+var routeParams = map[string]string{
+	"projects":   "{project_id}",
+	"branches":   "{branch_id}",
+	"endpoints":  "{endpoint_id}",
+	"operations": "{operation_id}",
+	"roles":      "{role_name}",
+	"databases":  "{database_name}",
+}
+
+// parseRoute parses a request path. Segments before the first known
+// collection, such as the /api/v2 prefix, are not part of the template.
+func parseRoute(path string) route {
+	var r route
+	var b strings.Builder
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	started := false
+	for i := 0; i < len(parts); i++ {
+		param, ok := routeParams[parts[i]]
+		if !started && !ok {
+			continue
+		}
+		started = true
+		b.WriteString("/" + parts[i])
+		if !ok || i+1 >= len(parts) {
+			continue
+		}
+		i++
+		b.WriteString("/" + param)
+		switch parts[i-1] {
+		case "projects":
+			r.projectId = parts[i]
+		case "branches":
+			r.branchId = parts[i]
+		case "endpoints":
+			r.endpointId = parts[i]
+		case "operations":
+			r.operationId = parts[i]
+		}
+	}
+	r.template = b.String()
+	if r.template == "" {
+		r.template = "/"
+	}
+	return r
+}
+
+func (r route) attributes() []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	for _, kv := range []attribute.KeyValue{
+		ProjectIdKey.String(r.projectId),
+		BranchIdKey.String(r.branchId),
+		EndpointIdKey.String(r.endpointId),
+		OperationIdKey.String(r.operationId),
+	} {
+		if kv.Value.AsString() != "" {
+			attrs = append(attrs, kv)
+		}
+	}
+	return attrs
+}
+
+// tracingTransport records a client span for every Neon API call. Retries
+// happen inside the span and are added to it as events.
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := parseRoute(req.URL.Path)
+	attrs := append(r.attributes(),
+		semconv.HTTPMethodKey.String(req.Method),
+		semconv.HTTPRouteKey.String(r.template),
+	)
+	ctx, span := tracer.Start(req.Context(), "neon "+req.Method+" "+r.template,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+	defer span.End()
+
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		span.RecordError(redact.Error(err))
+		span.SetStatus(codes.Error, redact.String(err.Error()))
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+	}
+	return resp, nil
+}